@@ -26,11 +26,22 @@ type AnnouncementResponse struct {
 // SendAnnouncement messages are sent to all users of the application or to a list of users.
 // These Messages will arrive outside of the context of a conversation
 func (l *Layer) SendAnnouncement(req AnnouncementRequest) (AnnouncementResponse, error) {
+	if err := validateRecipients(req.Recipients); err != nil {
+		return AnnouncementResponse{}, err
+	}
+	return l.sendAnnouncement(req, nil)
+}
+
+// sendAnnouncement posts an announcement, letting Layer discard it as a duplicate when dedupe was already used
+func (l *Layer) sendAnnouncement(req AnnouncementRequest, dedupe *string) (AnnouncementResponse, error) {
+	if err := req.Notification.Validate(); err != nil {
+		return AnnouncementResponse{}, err
+	}
 	body, err := json.Marshal(&req)
 	if err != nil {
 		return AnnouncementResponse{}, err
 	}
-	p := Parameters{Path: "announcements", Body: body}
+	p := Parameters{Path: "announcements", Body: body, Dedupe: dedupe}
 	resp, err := l.request("POST", &p)
 
 	if err != nil {
@@ -38,6 +49,10 @@ func (l *Layer) SendAnnouncement(req AnnouncementRequest) (AnnouncementResponse,
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return AnnouncementResponse{}, err
+	}
+
 	ar := AnnouncementResponse{}
 	json.NewDecoder(resp.Body).Decode(&ar)
 	return ar, err
@@ -0,0 +1,184 @@
+package layer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ArchiveVersion is the version of the archive format written by ExportConversation
+const ArchiveVersion = 1
+
+var (
+	// ErrConversationExists is returned when importing a distinct conversation whose participants already have
+	// one, which is left untouched
+	ErrConversationExists = errors.New("Distinct Conversation Already Exists")
+	// ErrArchivedContent is returned when importing an archive whose parts refer to rich content instead of
+	// holding its bytes
+	ErrArchivedContent = errors.New("Archived Part Refers To Rich Content")
+)
+
+// ConversationArchive is the serialized form of a conversation and its full message history
+type ConversationArchive struct {
+	Version      int                  `json:"version"`
+	ExportedAt   time.Time            `json:"exported_at"`
+	Conversation ArchivedConversation `json:"conversation"`
+	Messages     []ArchivedMessage    `json:"messages"`
+}
+
+// ArchivedConversation holds the conversation fields needed to recreate it
+type ArchivedConversation struct {
+	ID           string      `json:"id"`
+	Participants []string    `json:"participants"`
+	Distinct     bool        `json:"distinct"`
+	MetaData     interface{} `json:"metadata,omitempty"`
+	Created      time.Time   `json:"created_at"`
+}
+
+// ArchivedMessage holds a single message of an archived conversation. Rich content is downloaded into the
+// archive as base64 encoded part bodies, since its download URLs expire and its IDs only exist in one app.
+type ArchivedMessage struct {
+	ID     string    `json:"id"`
+	Sender Sender    `json:"sender"`
+	Parts  []Parts   `json:"parts"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// ExportConversation writes a conversation and every one of its messages, oldest first, as a JSON archive
+func (l *Layer) ExportConversation(convID string, w io.Writer) error {
+	a, err := l.archiveConversation(convID)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(&a)
+}
+
+// ImportConversation recreates an archived conversation and replays its messages, including those sent by the
+// system, in order. Message IDs and timestamps are assigned anew by Layer. A distinct conversation is only
+// imported if its participants have none yet, otherwise ErrConversationExists is returned. If a message cannot
+// be replayed the new conversation is deleted, or, when that fails too, returned along with the error so it can
+// be cleaned up. Part bodies over the inline limit are uploaded as rich content again.
+func (l *Layer) ImportConversation(r io.Reader) (ConversationResponse, error) {
+	a := ConversationArchive{}
+	if err := json.NewDecoder(r).Decode(&a); err != nil {
+		return ConversationResponse{}, err
+	}
+	if a.Version != ArchiveVersion {
+		return ConversationResponse{}, fmt.Errorf("Unsupported archive version %d", a.Version)
+	}
+
+	for _, m := range a.Messages {
+		for i, p := range m.Parts {
+			if p.Content != nil {
+				return ConversationResponse{}, fmt.Errorf("Archived message %s part %d: %w", m.ID, i, ErrArchivedContent)
+			}
+		}
+	}
+
+	c := a.Conversation
+	cr, created, err := l.createConversation(c.Participants, c.Distinct, c.MetaData)
+	if err != nil {
+		return cr, err
+	}
+	if !created {
+		// never replay into, or roll back, a conversation this import did not create
+		return ConversationResponse{}, fmt.Errorf("%w: %s", ErrConversationExists, cr.GetID())
+	}
+
+	convID := cr.GetID()
+	for _, m := range a.Messages {
+		sender := Sender{UserID: m.Sender.UserID}
+		if m.Sender.Kind() == SenderKindSystem {
+			sender = Sender{Name: m.Sender.Name}
+		}
+		parts, err := l.uploadLargeParts(convID, m.Parts)
+		if err == nil {
+			_, err = l.SendMessageAs(convID, sender, parts, Notification{})
+		}
+		if err != nil {
+			// do not leave a conversation with a partial history behind
+			if _, derr := l.DeleteConversation(convID); derr != nil {
+				return cr, fmt.Errorf("Archived message %s: %w (conversation %s left partially imported: %v)", m.ID, err, convID, derr)
+			}
+			return ConversationResponse{}, fmt.Errorf("Archived message %s: %w", m.ID, err)
+		}
+	}
+	return cr, nil
+}
+
+func (l *Layer) archiveConversation(convID string) (ConversationArchive, error) {
+	cr, err := l.GetConversation(convID)
+	if err != nil {
+		return ConversationArchive{}, err
+	}
+	if cr.GetID() != convID {
+		return ConversationArchive{}, fmt.Errorf("Conversation %s not found", convID)
+	}
+
+	a := ConversationArchive{
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Conversation: ArchivedConversation{
+			ID:           cr.ID,
+			Participants: cr.Participants,
+			Distinct:     cr.Distinct,
+			MetaData:     cr.MetaData,
+			Created:      cr.Created,
+		},
+		Messages: []ArchivedMessage{},
+	}
+
+	path := fmt.Sprintf("conversations/%s/messages", convID)
+	params := QueryParameters{PageSize: maxPageSize}
+	for {
+		page, err := l.getMessages(path, &params)
+		if err != nil {
+			return a, err
+		}
+
+		for _, m := range page {
+			parts, err := l.archiveParts(m.Parts)
+			if err != nil {
+				return a, fmt.Errorf("Message %s: %w", m.ID, err)
+			}
+			a.Messages = append(a.Messages, ArchivedMessage{ID: m.ID, Sender: m.Sender, Parts: parts, SentAt: m.SentAt})
+		}
+
+		if len(page) < maxPageSize {
+			break
+		}
+		params.FromID = page[len(page)-1].GetID()
+	}
+
+	sort.SliceStable(a.Messages, func(i, j int) bool {
+		return a.Messages[i].SentAt.Before(a.Messages[j].SentAt)
+	})
+	return a, nil
+}
+
+// archiveParts returns the parts with the bytes of every rich content part downloaded into its body
+func (l *Layer) archiveParts(parts []Parts) ([]Parts, error) {
+	res := make([]Parts, len(parts))
+	for i, p := range parts {
+		if p.Content == nil {
+			res[i] = p
+			continue
+		}
+
+		r, err := l.DownloadRichContent(*p.Content)
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		res[i] = Parts{ID: p.ID, MimeType: p.MimeType, Body: base64.StdEncoding.EncodeToString(b), Encoding: Base64Encoding}
+	}
+	return res, nil
+}
@@ -0,0 +1,98 @@
+package layer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportConversation(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, false, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	for _, body := range []string{"first", "second"} {
+		_, err := l.SendMessage(convID, user1, []Parts{Parts{Body: body, MimeType: "text/plain"}}, Notification{})
+		require.NoError(t, err)
+	}
+
+	buf := bytes.Buffer{}
+	require.NoError(t, l.ExportConversation(convID, &buf))
+
+	a := ConversationArchive{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &a))
+	require.Equal(t, ArchiveVersion, a.Version)
+	require.Contains(t, a.Conversation.Participants, user1)
+	require.Len(t, a.Messages, 2)
+	require.Equal(t, "first", a.Messages[0].Parts[0].Body)
+
+	res2, err := l.ImportConversation(&buf)
+	require.NoError(t, err)
+	defer cleanUpConversation(res2.GetID())
+	require.NotEqual(t, convID, res2.GetID())
+
+	msgs, err := l.GetAllMessages(res2.GetID())
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+}
+
+func TestImportConversationVersion(t *testing.T) {
+	_, err := l.ImportConversation(bytes.NewBufferString(`{"version": 99}`))
+	require.Error(t, err)
+}
+
+func TestImportConversationDistinct(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	_, err = l.SendMessage(convID, user1, []Parts{TextPart("existing")}, Notification{})
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	require.NoError(t, l.ExportConversation(convID, &buf))
+
+	_, err = l.ImportConversation(&buf)
+	require.True(t, errors.Is(err, ErrConversationExists))
+
+	msgs, err := l.GetAllMessages(convID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+}
+
+func TestArchiveParts(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 300)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	c := Content{ID: "layer:///content/1", Size: int64(len(payload)), DownloadURL: srv.URL, Expiration: time.Now().Add(time.Hour)}
+	parts, err := l.archiveParts([]Parts{TextPart("hello"), Parts{MimeType: "image/png", Content: &c}})
+	require.NoError(t, err)
+	require.Equal(t, TextPart("hello"), parts[0])
+	require.Nil(t, parts[1].Content)
+	require.Equal(t, Base64Encoding, parts[1].Encoding)
+	require.Equal(t, base64.StdEncoding.EncodeToString(payload), parts[1].Body)
+}
+
+func TestImportConversationContent(t *testing.T) {
+	a := `{"version": 1, "conversation": {"participants": ["a", "b"]},
+		"messages": [{"id": "m1", "sender": {"user_id": "a"}, "parts": [{"mime_type": "image/png", "content": {"id": "layer:///content/1"}}]}]}`
+	_, err := l.ImportConversation(bytes.NewBufferString(a))
+	require.True(t, errors.Is(err, ErrArchivedContent))
+}
@@ -0,0 +1,103 @@
+package layer
+
+import (
+	"errors"
+)
+
+// EveryoneRecipient is the recipient that sends an announcement to the entire userbase
+const EveryoneRecipient = "everyone"
+
+var (
+	// ErrEmptyAudience is returned when an announcement has no recipients
+	ErrEmptyAudience = errors.New("Empty Audience")
+	// ErrMixedAudience is returned when an announcement is addressed to everyone and to specific users
+	ErrMixedAudience = errors.New("Audience Mixes Everyone And Users")
+	// ErrEveryoneAsUser is returned when "everyone" is passed to Users instead of using Everyone
+	ErrEveryoneAsUser = errors.New("Everyone Is Not A User ID, Use Everyone()")
+	// ErrEveryoneNotConfirmed is returned when an announcement to everyone is built without ConfirmEveryone
+	ErrEveryoneNotConfirmed = errors.New("Announcement To Everyone Not Confirmed")
+)
+
+// Audience is the set of recipients of an announcement, built with Everyone or Users
+type Audience struct {
+	everyone bool
+	users    []string
+}
+
+// AnnouncementOptions configures NewAnnouncement and Announce
+type AnnouncementOptions struct {
+	// ConfirmEveryone must be set to send to Everyone, guarding against messaging the whole userbase by mistake
+	ConfirmEveryone bool
+}
+
+// Everyone is the audience of every user of the application
+func Everyone() Audience {
+	return Audience{everyone: true}
+}
+
+// Users is the audience of the given user IDs. Duplicate and empty IDs are dropped. The audience is invalid if
+// an ID is "everyone", so that the whole userbase can only be addressed with Everyone.
+func Users(ids ...string) Audience {
+	a := Audience{users: []string{}}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		a.users = append(a.users, id)
+	}
+	return a
+}
+
+// IsEveryone reports whether the audience is the entire userbase
+func (a Audience) IsEveryone() bool {
+	return a.everyone
+}
+
+// Recipients returns the audience as the recipients of an AnnouncementRequest
+func (a Audience) Recipients() []string {
+	if a.everyone {
+		return []string{EveryoneRecipient}
+	}
+	return append([]string{}, a.users...)
+}
+
+// Validate checks that the audience is not empty and does not smuggle "everyone" in as a user ID
+func (a Audience) Validate() error {
+	if !a.everyone && containsString(a.users, EveryoneRecipient) {
+		return ErrEveryoneAsUser
+	}
+	return validateRecipients(a.Recipients())
+}
+
+// NewAnnouncement builds an AnnouncementRequest for the audience, rejecting invalid audiences
+func NewAnnouncement(a Audience, sender Sender, parts []Parts, n Notification, opts AnnouncementOptions) (AnnouncementRequest, error) {
+	if err := a.Validate(); err != nil {
+		return AnnouncementRequest{}, err
+	}
+	if a.everyone && !opts.ConfirmEveryone {
+		return AnnouncementRequest{}, ErrEveryoneNotConfirmed
+	}
+	return AnnouncementRequest{Recipients: a.Recipients(), Sender: sender, Parts: parts, Notification: n}, nil
+}
+
+// Announce sends an announcement to the audience, see NewAnnouncement
+func (l *Layer) Announce(a Audience, sender Sender, parts []Parts, n Notification, opts AnnouncementOptions) (AnnouncementResponse, error) {
+	req, err := NewAnnouncement(a, sender, parts, n, opts)
+	if err != nil {
+		return AnnouncementResponse{}, err
+	}
+	return l.SendAnnouncement(req)
+}
+
+// validateRecipients rejects an empty recipient list or one mixing "everyone" with user IDs
+func validateRecipients(recipients []string) error {
+	if len(recipients) == 0 {
+		return ErrEmptyAudience
+	}
+	if containsString(recipients, EveryoneRecipient) && len(recipients) > 1 {
+		return ErrMixedAudience
+	}
+	return nil
+}
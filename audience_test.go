@@ -0,0 +1,46 @@
+package layer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudience(t *testing.T) {
+	a := Users("b", "a", "", "b")
+	require.False(t, a.IsEveryone())
+	require.Equal(t, []string{"b", "a"}, a.Recipients())
+	require.NoError(t, a.Validate())
+
+	require.Equal(t, ErrEmptyAudience, Users().Validate())
+	require.Equal(t, ErrEmptyAudience, Users("").Validate())
+	require.Equal(t, ErrEveryoneAsUser, Users("a", EveryoneRecipient).Validate())
+	require.Equal(t, ErrEveryoneAsUser, Users(EveryoneRecipient).Validate())
+
+	require.True(t, Everyone().IsEveryone())
+	require.Equal(t, []string{EveryoneRecipient}, Everyone().Recipients())
+}
+
+func TestNewAnnouncement(t *testing.T) {
+	parts := []Parts{TextPart("Hello")}
+	req, err := NewAnnouncement(Users("a", "a", "b"), Sender{Name: "Admin"}, parts, Notification{}, AnnouncementOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, req.Recipients)
+	require.Equal(t, "Admin", req.Sender.Name)
+
+	_, err = NewAnnouncement(Everyone(), Sender{Name: "Admin"}, parts, Notification{}, AnnouncementOptions{})
+	require.Equal(t, ErrEveryoneNotConfirmed, err)
+
+	req, err = NewAnnouncement(Everyone(), Sender{Name: "Admin"}, parts, Notification{}, AnnouncementOptions{ConfirmEveryone: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{EveryoneRecipient}, req.Recipients)
+
+	_, err = NewAnnouncement(Users(), Sender{Name: "Admin"}, parts, Notification{}, AnnouncementOptions{ConfirmEveryone: true})
+	require.Equal(t, ErrEmptyAudience, err)
+
+	_, err = NewAnnouncement(Users(EveryoneRecipient), Sender{Name: "Admin"}, parts, Notification{}, AnnouncementOptions{})
+	require.Equal(t, ErrEveryoneAsUser, err)
+
+	_, err = l.SendAnnouncement(AnnouncementRequest{Recipients: []string{"a", EveryoneRecipient}, Parts: parts})
+	require.Equal(t, ErrMixedAudience, err)
+}
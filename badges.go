@@ -0,0 +1,67 @@
+package layer
+
+import (
+	"sort"
+)
+
+// BadgeOptions configures GetUsersBadges and SetUsersBadges
+type BadgeOptions struct {
+	// Concurrency is the number of simultaneous requests, defaults to DefaultConcurrency
+	Concurrency int
+	// LastKnown holds the external unread count last set for each user, users whose count is unchanged are skipped
+	LastKnown map[string]int
+}
+
+// BadgeResult is the outcome of reading or setting the badge of one user
+type BadgeResult struct {
+	UserID string
+	// Badge holds the counts read by GetUsersBadges
+	Badge GetBadgeResponse
+	// Count is the external unread count given to SetUsersBadges
+	Count int
+	// Skipped is true when the count matched LastKnown and no request was made
+	Skipped bool
+	Err     error
+}
+
+// GetUsersBadges reads the badge of every user with bounded concurrency. Results are in the order of userIDs.
+func (l *Layer) GetUsersBadges(userIDs []string, opts BadgeOptions) []BadgeResult {
+	return getBadges(userIDs, opts, l.GetUsersBadge)
+}
+
+// SetUsersBadges sets the external unread count of every user in counts with bounded concurrency, skipping users
+// whose count equals their LastKnown value. Results are ordered by user ID.
+func (l *Layer) SetUsersBadges(counts map[string]int, opts BadgeOptions) []BadgeResult {
+	return setBadges(counts, opts, func(userID string, count int) error {
+		_, err := l.SetUsersBadge(userID, count)
+		return err
+	})
+}
+
+func getBadges(userIDs []string, opts BadgeOptions, get func(string) (GetBadgeResponse, error)) []BadgeResult {
+	results := make([]BadgeResult, len(userIDs))
+	parallel(len(userIDs), opts.Concurrency, func(i int) {
+		b, err := get(userIDs[i])
+		results[i] = BadgeResult{UserID: userIDs[i], Badge: b, Count: b.UnreadExternal, Err: err}
+	})
+	return results
+}
+
+func setBadges(counts map[string]int, opts BadgeOptions, set func(string, int) error) []BadgeResult {
+	results := make([]BadgeResult, 0, len(counts))
+	for u, c := range counts {
+		r := BadgeResult{UserID: u, Count: c}
+		if last, ok := opts.LastKnown[u]; ok && last == c {
+			r.Skipped = true
+		}
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].UserID < results[j].UserID })
+
+	parallel(len(results), opts.Concurrency, func(i int) {
+		if !results[i].Skipped {
+			results[i].Err = set(results[i].UserID, results[i].Count)
+		}
+	})
+	return results
+}
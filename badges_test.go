@@ -0,0 +1,49 @@
+package layer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBadges(t *testing.T) {
+	get := func(userID string) (GetBadgeResponse, error) {
+		if userID == "bad" {
+			return GetBadgeResponse{}, errors.New("failed")
+		}
+		return GetBadgeResponse{UnreadExternal: len(userID)}, nil
+	}
+
+	res := getBadges([]string{"a", "bad", "ccc"}, BadgeOptions{Concurrency: 2}, get)
+	require.Len(t, res, 3)
+	require.Equal(t, "a", res[0].UserID)
+	require.Equal(t, 1, res[0].Count)
+	require.Error(t, res[1].Err)
+	require.Equal(t, 3, res[2].Badge.UnreadExternal)
+}
+
+func TestSetBadges(t *testing.T) {
+	mu := sync.Mutex{}
+	set := map[string]int{}
+	res := setBadges(map[string]int{"a": 1, "b": 2, "c": 3, "bad": 4}, BadgeOptions{LastKnown: map[string]int{"a": 1, "b": 5}}, func(userID string, count int) error {
+		if userID == "bad" {
+			return errors.New("failed")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		set[userID] = count
+		return nil
+	})
+
+	require.Equal(t, map[string]int{"b": 2, "c": 3}, set)
+	require.Len(t, res, 4)
+	require.Equal(t, "a", res[0].UserID)
+	require.True(t, res[0].Skipped)
+	require.Equal(t, "b", res[1].UserID)
+	require.False(t, res[1].Skipped)
+	require.Equal(t, "bad", res[2].UserID)
+	require.Error(t, res[2].Err)
+	require.NoError(t, res[3].Err)
+}
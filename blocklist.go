@@ -3,7 +3,7 @@ package layer
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"sort"
 )
 
 // BlockedUser is Layer's representation for a blocked user
@@ -33,8 +33,8 @@ func (l *Layer) AddUserToBlockList(userID, blocked string) (ok bool, err error)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 204 {
-		return false, fmt.Errorf("Responded with Error Code %d", resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		return false, err
 	}
 	return true, nil
 }
@@ -48,6 +48,10 @@ func (l *Layer) GetUserBlockList(userID string) ([]BlockedUser, error) {
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return []BlockedUser{}, err
+	}
+
 	b := []BlockedUser{}
 	json.NewDecoder(resp.Body).Decode(&b)
 	return b, nil
@@ -62,12 +66,81 @@ func (l *Layer) UnblockUser(userID, blockID string) (ok bool, err error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 204 {
-		return false, fmt.Errorf("Responded with Error Code %d", resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		return false, err
 	}
 	return true, nil
 }
 
+// BlockListDiff reports the changes needed to bring a user's block list to the desired state
+type BlockListDiff struct {
+	UserID string
+	// Block and Unblock hold the user IDs added to and removed from the block list, sorted
+	Block   []string
+	Unblock []string
+	// Unchanged is the number of users blocked both before and after
+	Unchanged int
+	// Applied is true once the changes were sent to Layer, it stays false for a dry run or when nothing changed
+	Applied bool
+}
+
+// ReconcileOptions configures ReconcileBlockList
+type ReconcileOptions struct {
+	// DryRun computes the diff without modifying the block list
+	DryRun bool
+}
+
+// ReconcileBlockList makes the block list of userID exactly desired, applying every change in a single bulk
+// operation, and returns the diff
+func (l *Layer) ReconcileBlockList(userID string, desired []string, opts ReconcileOptions) (BlockListDiff, error) {
+	current, err := l.GetUserBlockList(userID)
+	if err != nil {
+		return BlockListDiff{}, err
+	}
+
+	d := diffBlockList(userID, current, desired)
+	if opts.DryRun || len(d.Block)+len(d.Unblock) == 0 {
+		return d, nil
+	}
+
+	if _, err := l.BulkModifyBlockList(userID, d.Block, d.Unblock); err != nil {
+		return d, err
+	}
+	d.Applied = true
+	return d, nil
+}
+
+func diffBlockList(userID string, current []BlockedUser, desired []string) BlockListDiff {
+	d := BlockListDiff{UserID: userID, Block: []string{}, Unblock: []string{}}
+
+	want := map[string]bool{}
+	for _, id := range desired {
+		if id != "" {
+			want[id] = true
+		}
+	}
+	have := map[string]bool{}
+	for _, b := range current {
+		have[b.UserID] = true
+	}
+
+	for id := range want {
+		if have[id] {
+			d.Unchanged++
+		} else {
+			d.Block = append(d.Block, id)
+		}
+	}
+	for id := range have {
+		if !want[id] {
+			d.Unblock = append(d.Unblock, id)
+		}
+	}
+	sort.Strings(d.Block)
+	sort.Strings(d.Unblock)
+	return d
+}
+
 // BulkModifyBlockList supports bulk operations on a user's blocklist
 func (l *Layer) BulkModifyBlockList(userID string, blockIDs, unBlockIDs []string) (ok bool, err error) {
 
@@ -91,8 +164,8 @@ func (l *Layer) BulkModifyBlockList(userID string, blockIDs, unBlockIDs []string
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusAccepted {
-		return false, fmt.Errorf("Responded with Error Code %d", resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		return false, err
 	}
 	return true, nil
 }
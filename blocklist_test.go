@@ -79,3 +79,36 @@ func TestBulkModifyBlockList(t *testing.T) {
 	require.Equal(t, b[0].UserID, user2)
 
 }
+
+func TestDiffBlockList(t *testing.T) {
+	current := []BlockedUser{BlockedUser{UserID: "a"}, BlockedUser{UserID: "b"}, BlockedUser{UserID: "c"}}
+	d := diffBlockList("user1", current, []string{"d", "b", "", "a", "e", "d"})
+	require.Equal(t, "user1", d.UserID)
+	require.Equal(t, []string{"d", "e"}, d.Block)
+	require.Equal(t, []string{"c"}, d.Unblock)
+	require.Equal(t, 2, d.Unchanged)
+
+	d = diffBlockList("user1", nil, nil)
+	require.Empty(t, d.Block)
+	require.Empty(t, d.Unblock)
+}
+
+func TestReconcileBlockList(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	user3 := uuid.New()
+
+	ok, err := l.AddUserToBlockList(user1, user2)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	d, err := l.ReconcileBlockList(user1, []string{user3}, ReconcileOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{user3}, d.Block)
+	require.Equal(t, []string{user2}, d.Unblock)
+	require.False(t, d.Applied)
+
+	d, err = l.ReconcileBlockList(user1, []string{user3}, ReconcileOptions{})
+	require.NoError(t, err)
+	require.True(t, d.Applied)
+}
@@ -0,0 +1,91 @@
+package layer
+
+import (
+	"sync"
+)
+
+// DefaultConcurrency is the number of workers used by bulk operations when none is given
+const DefaultConcurrency = 8
+
+// ConversationSpec describes a conversation to be created by CreateConversations
+type ConversationSpec struct {
+	Participants []string
+	Distinct     bool
+	MetaData     interface{}
+}
+
+// BulkConversationResult is the outcome of creating a single ConversationSpec.
+// Index is the position of the spec in the input stream. Err is an *APIError when Layer rejected the request,
+// whose Temporary method reports whether the spec is worth retrying.
+type BulkConversationResult struct {
+	Index int
+	Spec  ConversationSpec
+	ID    string
+	Err   error
+}
+
+// CreateConversations creates a conversation for every spec read from specs using at most concurrency
+// simultaneous requests. Results are delivered as each request completes, so they are not ordered, and the
+// returned channel is closed once specs has been closed and drained.
+func (l *Layer) CreateConversations(specs <-chan ConversationSpec, concurrency int) <-chan BulkConversationResult {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	jobs := make(chan BulkConversationResult)
+	results := make(chan BulkConversationResult)
+
+	go func() {
+		i := 0
+		for s := range specs {
+			jobs <- BulkConversationResult{Index: i, Spec: s}
+			i++
+		}
+		close(jobs)
+	}()
+
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				cr, err := l.CreateConversation(r.Spec.Participants, r.Spec.Distinct, r.Spec.MetaData)
+				r.ID, r.Err = cr.GetID(), err
+				results <- r
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// parallel calls fn with every index in [0, n) from at most concurrency goroutines and waits for them to finish
+func parallel(n, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package layer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateConversations(t *testing.T) {
+	specs := make(chan ConversationSpec)
+	go func() {
+		for i := 0; i < 5; i++ {
+			specs <- ConversationSpec{Participants: []string{uuid.New(), uuid.New()}, Distinct: true}
+		}
+		specs <- ConversationSpec{}
+		close(specs)
+	}()
+
+	seen := map[int]bool{}
+	for r := range l.CreateConversations(specs, 2) {
+		seen[r.Index] = true
+		if r.Index == 5 {
+			require.Error(t, r.Err)
+			continue
+		}
+		require.NoError(t, r.Err)
+		require.NotEmpty(t, r.ID)
+		cleanUpConversation(r.ID)
+	}
+	require.Len(t, seen, 6)
+}
+
+func TestParallel(t *testing.T) {
+	var sum, running, peak int64
+	parallel(100, 4, func(i int) {
+		n := atomic.AddInt64(&running, 1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		atomic.AddInt64(&sum, int64(i))
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&running, -1)
+	})
+
+	require.Equal(t, int64(4950), sum)
+	require.True(t, peak <= 4)
+}
@@ -0,0 +1,409 @@
+package layer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxInlineBodySize is the largest part body, in bytes, Layer accepts inline in a message
+	MaxInlineBodySize = 2048
+
+	// uploadChunkSize must be a multiple of 256KB as required by the storage service behind upload URLs
+	uploadChunkSize  = 32 * 256 * 1024
+	maxUploadRetries = 3
+
+	// expirySkew is how long before its expiration a download URL is considered expired
+	expirySkew = 30 * time.Second
+)
+
+// uploadRetryDelay is the delay before the first retry of a chunk, doubled for each further retry
+var uploadRetryDelay = time.Second
+
+var (
+	// ErrEmptyContent is returned when uploading rich content with no bytes
+	ErrEmptyContent = errors.New("Empty Content")
+	// ErrUploadOutOfSync is returned when the upload server reports an offset the upload can no longer resume from
+	ErrUploadOutOfSync = errors.New("Upload Out Of Sync")
+	// ErrContentSizeMismatch is returned when downloaded content is longer than its descriptor states
+	ErrContentSizeMismatch = errors.New("Content Size Mismatch")
+	// ErrInvalidRange is returned when a download range lies outside of the content
+	ErrInvalidRange = errors.New("Invalid Range")
+)
+
+// Content is Layer's descriptor for rich content, the body of a message part stored outside of the message.
+// DownloadURL is only valid until Expiration, after which a new one is fetched from RefreshURL.
+type Content struct {
+	ID          string    `json:"id,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	UploadURL   string    `json:"upload_url,omitempty"`
+	DownloadURL string    `json:"download_url,omitempty"`
+	Expiration  time.Time `json:"expiration,omitempty"`
+	RefreshURL  string    `json:"refresh_url,omitempty"`
+}
+
+// MarshalJSON omits a zero Expiration so that descriptors can be sent in message parts
+func (c Content) MarshalJSON() ([]byte, error) {
+	type alias Content
+	aux := struct {
+		alias
+		Expiration *time.Time `json:"expiration,omitempty"`
+	}{alias: alias(c)}
+	if !c.Expiration.IsZero() {
+		aux.Expiration = &c.Expiration
+	}
+	return json.Marshal(aux)
+}
+
+// UploadRichContent allows messages whose body is larger than 2KB to be sent. It requests an upload slot for the
+// conversation and streams size bytes from r to it in chunks, retrying interrupted chunks from the last offset
+// the upload server acknowledged. The returned Content can be set on a message Parts. If the upload fails after
+// the slot was created, the returned Content still holds the UploadURL and can be passed to ResumeRichContent.
+func (l *Layer) UploadRichContent(convID, mimeType string, size int64, r io.Reader) (Content, error) {
+	if size <= 0 {
+		return Content{}, ErrEmptyContent
+	}
+
+	p := Parameters{
+		Path: fmt.Sprintf("conversations/%s/content", convID),
+		Headers: map[string]string{
+			"Upload-Content-Type":   mimeType,
+			"Upload-Content-Length": strconv.FormatInt(size, 10),
+		},
+	}
+	resp, err := l.request("POST", &p)
+	if err != nil {
+		return Content{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return Content{}, err
+	}
+
+	c := Content{}
+	json.NewDecoder(resp.Body).Decode(&c)
+	if c.Size == 0 {
+		c.Size = size
+	}
+
+	if err := l.uploadContent(c, r, 0, uploadChunkSize); err != nil {
+		return c, err
+	}
+	c.UploadURL = ""
+	return c, nil
+}
+
+// ResumeRichContent continues an upload started by UploadRichContent. r must hold the complete content, it is
+// positioned at the first byte the upload server has not yet received.
+func (l *Layer) ResumeRichContent(c Content, r io.ReadSeeker) (Content, error) {
+	offset, err := l.uploadedBytes(c)
+	if err != nil {
+		return c, err
+	}
+
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return c, err
+	}
+
+	if err := l.uploadContent(c, r, offset, uploadChunkSize); err != nil {
+		return c, err
+	}
+	c.UploadURL = ""
+	return c, nil
+}
+
+// uploadLargeParts uploads the body of every part larger than MaxInlineBodySize and returns the parts with
+// those bodies replaced by their content descriptors. Inline parts are returned unchanged.
+func (l *Layer) uploadLargeParts(convID string, parts []Parts) ([]Parts, error) {
+	res := make([]Parts, len(parts))
+	for i, p := range parts {
+		if len(p.Body) <= MaxInlineBodySize {
+			res[i] = p
+			continue
+		}
+
+		body := []byte(p.Body)
+		if p.Encoding == Base64Encoding {
+			b, err := base64.StdEncoding.DecodeString(p.Body)
+			if err != nil {
+				return nil, err
+			}
+			body = b
+		}
+
+		c, err := l.UploadRichContent(convID, p.MimeType, int64(len(body)), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		res[i] = Parts{ID: p.ID, MimeType: p.MimeType, Content: &c}
+	}
+	return res, nil
+}
+
+// uploadContent sends the content read from r, which is positioned at offset, in chunks of chunkSize bytes
+func (l *Layer) uploadContent(c Content, r io.Reader, offset, chunkSize int64) error {
+	buf := make([]byte, chunkSize)
+	for offset < c.Size {
+		n := c.Size - offset
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+
+		start, end := offset, offset+n
+		lastErr := ErrUploadOutOfSync
+		delay := time.Duration(0)
+		for attempts := 0; offset < end; attempts++ {
+			if attempts > maxUploadRetries {
+				return lastErr
+			}
+			// back off while the storage service is failing or rate limiting
+			time.Sleep(delay)
+
+			committed, err := l.putContent(c, buf[offset-start:n], offset)
+			if err != nil {
+				if e, ok := err.(*APIError); ok && !e.Temporary() {
+					return err
+				}
+				lastErr = err
+				if delay *= 2; delay == 0 {
+					delay = uploadRetryDelay
+				}
+				if committed, err = l.uploadedBytes(c); err != nil {
+					continue
+				}
+			}
+
+			if committed < start || committed > end {
+				return ErrUploadOutOfSync
+			}
+			offset = committed
+		}
+	}
+	return nil
+}
+
+// putContent sends chunk starting at offset and returns the number of bytes the upload server has committed
+func (l *Layer) putContent(c Content, chunk []byte, offset int64) (int64, error) {
+	rng := fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, c.Size)
+	return l.sendContentRange(c, chunk, rng)
+}
+
+// uploadedBytes asks the upload server how many bytes of the content it has committed
+func (l *Layer) uploadedBytes(c Content) (int64, error) {
+	return l.sendContentRange(c, nil, fmt.Sprintf("bytes */%d", c.Size))
+}
+
+func (l *Layer) sendContentRange(c Content, body []byte, rng string) (int64, error) {
+	client := http.Client{
+		Timeout: l.timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest("PUT", c.UploadURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", rng)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPermanentRedirect {
+		return committedBytes(resp.Header.Get("Range"))
+	}
+	if err := checkResponse(resp); err != nil {
+		return 0, err
+	}
+	return c.Size, nil
+}
+
+// committedBytes parses a Range header of the form "bytes=0-N" into the number of bytes received
+func committedBytes(rng string) (int64, error) {
+	if rng == "" {
+		return 0, nil
+	}
+
+	i := strings.LastIndex(rng, "-")
+	if !strings.HasPrefix(rng, "bytes=0-") || i < 0 {
+		return 0, fmt.Errorf("Invalid Range header %q", rng)
+	}
+
+	last, err := strconv.ParseInt(rng[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid Range header %q", rng)
+	}
+	return last + 1, nil
+}
+
+// RefreshRichContent fetches a new descriptor, with a fresh DownloadURL, for the content. The refresh URL comes
+// from message data, so the API token is only sent when it points at the Layer API.
+func (l *Layer) RefreshRichContent(c Content) (Content, error) {
+	if c.RefreshURL == "" {
+		return c, fmt.Errorf("Content %s has no refresh URL", c.ID)
+	}
+
+	var resp *http.Response
+	var err error
+	if isAPIURL(c.RefreshURL) {
+		resp, err = l.request("GET", &Parameters{URL: c.RefreshURL})
+	} else {
+		client := http.Client{Timeout: l.timeout}
+		resp, err = client.Get(c.RefreshURL)
+	}
+	if err != nil {
+		return c, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return c, err
+	}
+
+	r := Content{}
+	json.NewDecoder(resp.Body).Decode(&r)
+	if r.RefreshURL == "" {
+		r.RefreshURL = c.RefreshURL
+	}
+	return r, nil
+}
+
+// DownloadRichContent returns a reader over the whole content. The download URL is refreshed when it has
+// expired, and reading fails if the number of bytes received does not match the content size.
+func (l *Layer) DownloadRichContent(c Content) (io.ReadCloser, error) {
+	return l.DownloadRichContentRange(c, 0, c.Size)
+}
+
+// DownloadRichContentRange returns a reader over length bytes of the content starting at offset
+func (l *Layer) DownloadRichContentRange(c Content, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 || offset+length > c.Size {
+		return nil, ErrInvalidRange
+	}
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	refreshed := false
+	if c.DownloadURL == "" || (!c.Expiration.IsZero() && time.Now().Add(expirySkew).After(c.Expiration)) {
+		r, err := l.RefreshRichContent(c)
+		if err != nil {
+			return nil, err
+		}
+		c, refreshed = r, true
+	}
+
+	resp, err := l.getContentRange(c, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	// the URL may expire in between checking it and using it
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized) && !refreshed {
+		resp.Body.Close()
+		if c, err = l.RefreshRichContent(c); err != nil {
+			return nil, err
+		}
+		if resp, err = l.getContentRange(c, offset, length); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	// the storage service ignored the range and sent the whole content, whose size is checked instead
+	if resp.StatusCode == http.StatusOK && (offset > 0 || length < c.Size) {
+		if resp.ContentLength >= 0 && resp.ContentLength != c.Size {
+			resp.Body.Close()
+			return nil, ErrContentSizeMismatch
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		body := struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}
+		return &sizedReader{r: body, remaining: length}, nil
+	}
+
+	return &sizedReader{r: resp.Body, remaining: length}, nil
+}
+
+// isAPIURL reports whether u points at the Layer API, and may be sent the API token
+func isAPIURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	api, _ := url.Parse(base)
+	return parsed.Scheme == api.Scheme && parsed.Host == api.Host
+}
+
+func (l *Layer) getContentRange(c Content, offset, length int64) (*http.Response, error) {
+	client := http.Client{Timeout: l.timeout}
+
+	req, err := http.NewRequest("GET", c.DownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 || length < c.Size {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+
+	return client.Do(req)
+}
+
+// sizedReader reads exactly remaining bytes from r, failing if r ends early or holds more
+type sizedReader struct {
+	r         io.ReadCloser
+	remaining int64
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		// a single byte read is enough to detect surplus content
+		n, err := s.r.Read(make([]byte, 1))
+		if n > 0 {
+			return 0, ErrContentSizeMismatch
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (s *sizedReader) Close() error {
+	return s.r.Close()
+}
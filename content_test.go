@@ -0,0 +1,242 @@
+package layer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadServer is a stand-in for the resumable upload service behind Layer's upload URLs
+type uploadServer struct {
+	mu       sync.Mutex
+	data     []byte
+	size     int64
+	failures int // number of chunk requests to fail after storing half of their body
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rng := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	body, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(rng, "*/") {
+		start, _ := strconv.ParseInt(rng[:strings.Index(rng, "-")], 10, 64)
+		if start != int64(len(s.data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s.failures > 0 {
+			s.failures--
+			s.data = append(s.data, body[:len(body)/2]...)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.data = append(s.data, body...)
+	}
+
+	if int64(len(s.data)) == s.size {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(s.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.data)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func TestUploadContent(t *testing.T) {
+	defer func(d time.Duration) { uploadRetryDelay = d }(uploadRetryDelay)
+	uploadRetryDelay = time.Millisecond
+
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	s := &uploadServer{size: int64(len(payload)), failures: 2}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c := Content{ID: "layer:///content/1", Size: int64(len(payload)), UploadURL: srv.URL}
+	require.NoError(t, l.uploadContent(c, bytes.NewReader(payload), 0, 256))
+	require.Equal(t, payload, s.data)
+}
+
+func TestUploadContentFailure(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 10)
+	s := &uploadServer{size: int64(len(payload)), failures: 100}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	defer func(d time.Duration) { uploadRetryDelay = d }(uploadRetryDelay)
+	uploadRetryDelay = 10 * time.Millisecond
+
+	c := Content{ID: "layer:///content/1", Size: int64(len(payload)), UploadURL: srv.URL}
+	start := time.Now()
+	require.Error(t, l.uploadContent(c, bytes.NewReader(payload), 0, 256))
+	// three retries backed off by 10, 20 and 40ms
+	require.True(t, time.Since(start) >= 70*time.Millisecond)
+}
+
+func TestResumeRichContent(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	s := &uploadServer{size: int64(len(payload)), data: append([]byte{}, payload[:300]...)}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c := Content{ID: "layer:///content/1", Size: int64(len(payload)), UploadURL: srv.URL}
+	res, err := l.ResumeRichContent(c, bytes.NewReader(payload))
+	require.NoError(t, err)
+	require.Equal(t, "", res.UploadURL)
+	require.Equal(t, payload, s.data)
+}
+
+func TestCommittedBytes(t *testing.T) {
+	n, err := committedBytes("")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), n)
+
+	n, err = committedBytes("bytes=0-524287")
+	require.NoError(t, err)
+	require.Equal(t, int64(524288), n)
+
+	_, err = committedBytes("bytes=10-20")
+	require.Error(t, err)
+}
+
+func TestUploadLargePartsInline(t *testing.T) {
+	parts := []Parts{Parts{Body: "Hello World", MimeType: "text/plain"}}
+	res, err := l.uploadLargeParts("conv", parts)
+	require.NoError(t, err)
+	require.Equal(t, parts, res)
+
+	large := Parts{Body: strings.Repeat("!", MaxInlineBodySize+1), MimeType: "image/png", Encoding: "base64"}
+	_, err = l.uploadLargeParts("conv", []Parts{large})
+	require.Error(t, err)
+}
+
+func TestUploadRichContent(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	defer cleanUpConversation(res.GetID())
+
+	payload := bytes.Repeat([]byte("a"), 4096)
+	c, err := l.UploadRichContent(res.GetID(), "text/plain", int64(len(payload)), bytes.NewReader(payload))
+	require.NoError(t, err)
+	require.NotEmpty(t, c.ID)
+	require.Equal(t, int64(len(payload)), c.Size)
+
+	_, err = l.UploadRichContent(res.GetID(), "text/plain", 0, bytes.NewReader(nil))
+	require.Equal(t, ErrEmptyContent, err)
+}
+
+func TestContentMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(Content{ID: "layer:///content/1", Size: 10})
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"layer:///content/1","size":10}`, string(b))
+
+	exp := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	b, err = json.Marshal(Content{ID: "layer:///content/1", Expiration: exp})
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"layer:///content/1","expiration":"2016-01-01T00:00:00Z"}`, string(b))
+}
+
+func TestDownloadRichContent(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	refreshes := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fresh", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "content", time.Time{}, bytes.NewReader(payload))
+	})
+	mux.HandleFunc("/expired", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		// the token is never sent outside the Layer API
+		if r.Header.Get("Authorization") == "" {
+			refreshes++
+		}
+		json.NewEncoder(w).Encode(Content{ID: "layer:///content/1", Size: int64(len(payload)), DownloadURL: srv.URL + "/fresh", Expiration: time.Now().Add(time.Hour)})
+	})
+
+	c := Content{ID: "layer:///content/1", Size: int64(len(payload)), DownloadURL: srv.URL + "/expired", Expiration: time.Now().Add(time.Hour), RefreshURL: srv.URL + "/refresh"}
+	rc, err := l.DownloadRichContent(c)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, payload, b)
+	require.Equal(t, 1, refreshes)
+
+	c.Expiration = time.Now().Add(-time.Minute)
+	rc, err = l.DownloadRichContentRange(c, 10, 25)
+	require.NoError(t, err)
+	b, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, payload[10:35], b)
+	require.Equal(t, 2, refreshes)
+
+	_, err = l.DownloadRichContentRange(c, 990, 20)
+	require.Equal(t, ErrInvalidRange, err)
+}
+
+func TestDownloadRichContentRangeIgnored(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	rc, err := l.DownloadRichContentRange(Content{Size: 100, DownloadURL: srv.URL}, 10, 20)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, payload[10:30], b)
+
+	rc, err = l.DownloadRichContentRange(Content{Size: 100, DownloadURL: srv.URL}, 0, 20)
+	require.NoError(t, err)
+	b, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, payload[:20], b)
+
+	_, err = l.DownloadRichContentRange(Content{Size: 120, DownloadURL: srv.URL}, 10, 20)
+	require.Equal(t, ErrContentSizeMismatch, err)
+}
+
+func TestIsAPIURL(t *testing.T) {
+	require.True(t, isAPIURL(base+"/apps/1/content/2"))
+	require.False(t, isAPIURL("http://api.layer.com/apps/1/content/2"))
+	require.False(t, isAPIURL("https://api.layer.com.evil.com/content"))
+	require.False(t, isAPIURL("https://evil.com/?https://api.layer.com"))
+}
+
+func TestDownloadRichContentSizeMismatch(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	rc, err := l.DownloadRichContent(Content{Size: 50, DownloadURL: srv.URL})
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.Equal(t, ErrContentSizeMismatch, err)
+
+	rc, err = l.DownloadRichContent(Content{Size: 200, DownloadURL: srv.URL})
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -3,12 +3,25 @@ package layer
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
 
+// DeletionMode controls whose copy of a conversation or message is deleted
+type DeletionMode string
+
+const (
+	// DeleteForAllParticipants destroys the history for every participant
+	DeleteForAllParticipants DeletionMode = "all_participants"
+	// DeleteForMyDevices removes the history only for the user performing the deletion
+	DeleteForMyDevices DeletionMode = "my_devices"
+)
+
 var (
-	convHead = "layer:///conversations/"
+	convHead     = "layer:///conversations/"
+	identityHead = "layer:///identities/"
 )
 
 // ConversationResponse contains fields returned in the JSON response of requests made to the conversation endpoint
@@ -20,21 +33,25 @@ type ConversationResponse struct {
 	MetaData           interface{} `json:"metadata,omitempty"`
 	Distinct           bool        `json:"distinct,omitempty"`
 	LastMessage        LastMessage `json:"last_message,omitempty"`
-	UnreadMessageCount int         `json:"recipient_status,omitempty"`
-	Participants       []string    `json:"participants,omitempty"`
+	UnreadMessageCount int         `json:"unread_message_count,omitempty"`
+	// Participants holds the user ID of every participant regardless of API version
+	Participants []string `json:"participants,omitempty"`
+	// Identities holds the full participant identities, always set by clients using API version 2.0 and later
+	Identities []Identity `json:"-"`
 }
 
 // LastMessage contains information referring to the lastMessage in a ConversationResponse
 type LastMessage struct {
-	ID              string       `json:"id,omitempty"`
-	Position        int          `json:"position,omitempty"`
-	Conversation    Conversation `json:"conversation,omitempty"`
-	Parts           []Parts      `json:"parts,omitempty"`
-	SentAt          time.Time    `json:"sent_at,omitempty"`
-	ReceivedAt      time.Time    `json:"received_at,omitempty"`
-	Sender          Sender       `json:"sender,omitempty"`
-	Unread          bool         `json:"is_unread,omitempty"`
-	RecipientStatus []byte       `json:"recipient_status,omitempty"`
+	ID              string          `json:"id,omitempty"`
+	URL             string          `json:"url,omitempty"`
+	Position        int64           `json:"position,omitempty"`
+	Conversation    Conversation    `json:"conversation,omitempty"`
+	Parts           []Parts         `json:"parts,omitempty"`
+	SentAt          time.Time       `json:"sent_at,omitempty"`
+	ReceivedAt      time.Time       `json:"received_at,omitempty"`
+	Sender          Sender          `json:"sender,omitempty"`
+	Unread          bool            `json:"is_unread,omitempty"`
+	RecipientStatus RecipientStatus `json:"recipient_status,omitempty"`
 }
 
 // Conversation refers to the conversation object in a ConversationResponse
@@ -45,16 +62,96 @@ type Conversation struct {
 
 // Parts contains information pertaining to the parts of a conversation message
 type Parts struct {
-	ID       string `json:"id,omitempty"`
-	MimeType string `json:"mime_type,omitempty"`
-	Body     string `json:"body,omitempty"`
-	Encoding string `json:"encoding,omitempty"`
+	ID       string   `json:"id,omitempty"`
+	MimeType string   `json:"mime_type,omitempty"`
+	Body     string   `json:"body,omitempty"`
+	Encoding string   `json:"encoding,omitempty"`
+	Content  *Content `json:"content,omitempty"`
 }
 
-// Sender contains information pertaining to the Sender of the LastMessage within a ConversationResponse
+// Sender contains information pertaining to the Sender of the LastMessage within a ConversationResponse.
+// API version 1.0 sends user_id or name, later versions send an identity with id and display_name.
 type Sender struct {
-	UserID string `json:"user_id,omitempty"`
-	Name   string `json:"name,omitempty"`
+	ID          string `json:"id,omitempty"`
+	UserID      string `json:"user_id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// SenderKind distinguishes messages sent by participants from messages sent by the system
+type SenderKind string
+
+const (
+	// SenderKindUser is a message sent by a participant, identified by their user ID
+	SenderKindUser SenderKind = "user"
+	// SenderKindSystem is a message sent by the system under a display name
+	SenderKindSystem SenderKind = "system"
+)
+
+// Kind returns whether the sender is a participant or the system, or "" when neither is set
+func (s Sender) Kind() SenderKind {
+	switch {
+	case s.UserID != "":
+		return SenderKindUser
+	case s.Name != "":
+		return SenderKindSystem
+	}
+	return ""
+}
+
+// Identity is the representation of a user returned by API version 2.0 and later
+type Identity struct {
+	ID          string      `json:"id,omitempty"`
+	URL         string      `json:"url,omitempty"`
+	UserID      string      `json:"user_id,omitempty"`
+	DisplayName string      `json:"display_name,omitempty"`
+	AvatarURL   string      `json:"avatar_url,omitempty"`
+	MetaData    interface{} `json:"metadata,omitempty"`
+}
+
+// UnmarshalJSON accepts participants as either user ID strings or identity objects
+func (c *ConversationResponse) UnmarshalJSON(b []byte) error {
+	type alias ConversationResponse
+	aux := struct {
+		*alias
+		Participants []json.RawMessage `json:"participants,omitempty"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	c.Participants, c.Identities = nil, nil
+	for _, raw := range aux.Participants {
+		var userID string
+		if err := json.Unmarshal(raw, &userID); err == nil {
+			c.Participants = append(c.Participants, userID)
+			continue
+		}
+
+		i := Identity{}
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return err
+		}
+		if i.UserID == "" {
+			i.UserID = strings.Replace(i.ID, identityHead, "", -1)
+		}
+		c.Identities = append(c.Identities, i)
+		c.Participants = append(c.Participants, i.UserID)
+	}
+	return nil
+}
+
+// UnmarshalJSON fills in UserID from the identity ID when only the latter is present
+func (s *Sender) UnmarshalJSON(b []byte) error {
+	type alias Sender
+	if err := json.Unmarshal(b, (*alias)(s)); err != nil {
+		return err
+	}
+	if s.UserID == "" && strings.HasPrefix(s.ID, identityHead) {
+		s.UserID = strings.Replace(s.ID, identityHead, "", -1)
+	}
+	return nil
 }
 
 type createConversationBody struct {
@@ -98,15 +195,19 @@ func (l *Layer) GetAllConversationsForUser(userID string, params *QueryParameter
 		return []ConversationResponse{}, ErrMissingUserID
 	}
 
-	p := Parameters{Path: fmt.Sprintf("users/%s/conversations", userID)}
+	p := Parameters{Path: fmt.Sprintf("users/%s/conversations%s", userID, params.encode())}
 	resp, err := l.request("GET", &p)
 	if err != nil {
 		return []ConversationResponse{}, err
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return []ConversationResponse{}, err
+	}
+
 	cr := []ConversationResponse{}
-	json.NewDecoder(resp.Body).Decode(&cr)
+	l.decode(resp.Body, &cr)
 	return cr, nil
 }
 
@@ -124,11 +225,32 @@ func (l *Layer) GetConversationForUser(userID, convID string, params *QueryParam
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return ConversationResponse{}, err
+	}
+
 	cr := ConversationResponse{}
-	json.NewDecoder(resp.Body).Decode(&cr)
+	l.decode(resp.Body, &cr)
 	return cr, nil
 }
 
+// DeleteConversationForUser deletes a conversation from a specific user's perspective, either for that user
+// alone or for all participants. The user remains a participant unless they leave the conversation.
+func (l *Layer) DeleteConversationForUser(userID, convID string, mode DeletionMode) (ok bool, err error) {
+	if userID == "" {
+		return false, ErrMissingUserID
+	}
+	return l.deleteWithMode(fmt.Sprintf("users/%s/conversations/%s", userID, convID), mode, false)
+}
+
+// LeaveConversation removes a user from a conversation and deletes its history for that user only
+func (l *Layer) LeaveConversation(userID, convID string) (ok bool, err error) {
+	if userID == "" {
+		return false, ErrMissingUserID
+	}
+	return l.deleteWithMode(fmt.Sprintf("users/%s/conversations/%s", userID, convID), DeleteForMyDevices, true)
+}
+
 // GetConversation requests the Conversation with the given ID
 func (l *Layer) GetConversation(convID string) (ConversationResponse, error) {
 	p := Parameters{Path: fmt.Sprintf("conversations/%s", convID)}
@@ -139,33 +261,48 @@ func (l *Layer) GetConversation(convID string) (ConversationResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return ConversationResponse{}, err
+	}
+
 	cr := ConversationResponse{}
-	json.NewDecoder(resp.Body).Decode(&cr)
+	l.decode(resp.Body, &cr)
 	return cr, nil
 }
 
 // CreateConversation creates a conversation between two or more participants
 func (l *Layer) CreateConversation(participants []string, distinct bool, metadata interface{}) (ConversationResponse, error) {
+	cr, _, err := l.createConversation(participants, distinct, metadata)
+	return cr, err
+}
+
+// createConversation also reports whether the conversation was created, as opposed to an existing distinct
+// conversation between the participants being returned
+func (l *Layer) createConversation(participants []string, distinct bool, metadata interface{}) (ConversationResponse, bool, error) {
 	cr := ConversationResponse{}
 	if len(participants) == 0 {
-		return cr, ErrEmptyParticipants
+		return cr, false, ErrEmptyParticipants
 	}
 
 	c := createConversationBody{Participants: participants, Distinct: distinct, MetaData: metadata}
 	body, err := json.Marshal(&c)
 	if err != nil {
-		return cr, err
+		return cr, false, err
 	}
 	p := Parameters{Path: "conversations", Body: body}
 
 	resp, err := l.request("POST", &p)
 	if err != nil {
-		return cr, err
+		return cr, false, err
 	}
 	defer resp.Body.Close()
 
-	json.NewDecoder(resp.Body).Decode(&cr)
-	return cr, nil
+	if err := checkResponse(resp); err != nil {
+		return cr, false, err
+	}
+
+	l.decode(resp.Body, &cr)
+	return cr, resp.StatusCode == http.StatusCreated, nil
 }
 
 // AddParticipants adds one or more participants to a conversation
@@ -207,8 +344,8 @@ func (l *Layer) editConversation(convID string, body []byte) (bool, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 204 {
-		return false, fmt.Errorf("Responded with Error Code %d", resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		return false, err
 	}
 	return true, nil
 
@@ -232,8 +369,8 @@ func (l *Layer) DeleteConversation(convID string) (ok bool, err error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 204 {
-		return false, fmt.Errorf("Responded with Error Code %d", resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		return false, err
 	}
 	return true, nil
 }
@@ -257,3 +394,27 @@ func (l *Layer) SetMetadata(convID, property string, value interface{}) (bool, e
 	}
 	return l.editConversation(convID, body)
 }
+
+func (l *Layer) deleteWithMode(path string, mode DeletionMode, leave bool) (bool, error) {
+	if mode != DeleteForAllParticipants && mode != DeleteForMyDevices {
+		return false, ErrInvalidDeletionMode
+	}
+
+	v := url.Values{}
+	v.Set("mode", string(mode))
+	if leave {
+		v.Set("leave", "true")
+	}
+
+	p := Parameters{Path: fmt.Sprintf("%s?%s", path, v.Encode())}
+	resp, err := l.request("DELETE", &p)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
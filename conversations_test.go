@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -71,6 +72,45 @@ func TestGetConversationForUser(t *testing.T) {
 	require.Contains(t, res2.Participants, user2)
 }
 
+func TestDeleteConversationForUser(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convoID := res.GetID()
+	defer cleanUpConversation(convoID)
+
+	ok, err := l.DeleteConversationForUser(user1, convoID, DeleteForMyDevices)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	res2, err := l.GetConversation(convoID)
+	require.NoError(t, err)
+	require.Contains(t, res2.Participants, user1)
+
+	_, err = l.DeleteConversationForUser(user1, convoID, DeletionMode("everyone"))
+	require.Equal(t, ErrInvalidDeletionMode, err)
+}
+
+func TestLeaveConversation(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	user3 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2, user3}, true, Conversation{})
+	require.NoError(t, err)
+	convoID := res.GetID()
+	defer cleanUpConversation(convoID)
+
+	ok, err := l.LeaveConversation(user3, convoID)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	res2, err := l.GetConversation(convoID)
+	require.NoError(t, err)
+	require.Len(t, res2.Participants, 2)
+	require.NotContains(t, res2.Participants, user3)
+}
+
 func TestGetConversation(t *testing.T) {
 	user1 := uuid.New()
 	user2 := uuid.New()
@@ -230,6 +270,39 @@ func TestSetMetadata(t *testing.T) {
 	require.Equal(t, md.UserID, md2.Admin.UserID)
 }
 
+func TestDecodeConversationV1(t *testing.T) {
+	cr := ConversationResponse{}
+	require.NoError(t, json.Unmarshal(loadFixture(t, "conversation_v1.json"), &cr))
+
+	require.Equal(t, "f3cc7b32-3c92-11e4-baad-164230d1df67", cr.GetID())
+	require.Equal(t, []string{"1234", "5678"}, cr.Participants)
+	require.Len(t, cr.Identities, 0)
+	require.Equal(t, 3, cr.UnreadMessageCount)
+	require.Equal(t, "1234", cr.LastMessage.Sender.UserID)
+	require.Equal(t, int64(15032697020), cr.LastMessage.Position)
+	require.True(t, cr.LastMessage.Unread)
+	require.Equal(t, RecipientStatus{"1234": "read", "5678": "delivered"}, cr.LastMessage.RecipientStatus)
+}
+
+func TestDecodeConversationV2(t *testing.T) {
+	cr := ConversationResponse{}
+	require.NoError(t, json.Unmarshal(loadFixture(t, "conversation_v2.json"), &cr))
+
+	require.Equal(t, []string{"1234", "5678"}, cr.Participants)
+	require.Len(t, cr.Identities, 2)
+	require.Equal(t, "One Two Three Four", cr.Identities[0].DisplayName)
+	require.Equal(t, "5678", cr.Identities[1].UserID)
+	require.Equal(t, "1234", cr.LastMessage.Sender.UserID)
+	require.Equal(t, "One Two Three Four", cr.LastMessage.Sender.DisplayName)
+	require.Equal(t, RecipientStatus{"1234": "read", "5678": "read"}, cr.LastMessage.RecipientStatus)
+}
+
+func loadFixture(t *testing.T, name string) []byte {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return b
+}
+
 func getConvID(s string) string {
 	return strings.Replace(s, convHead, "", -1)
 }
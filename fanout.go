@@ -0,0 +1,131 @@
+package layer
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultAnnouncementChunkSize is the number of recipients per announcement used by FanoutAnnouncement
+const DefaultAnnouncementChunkSize = 500
+
+// FanoutOptions configures FanoutAnnouncement
+type FanoutOptions struct {
+	// ChunkSize is the number of recipients per announcement, defaults to DefaultAnnouncementChunkSize
+	ChunkSize int
+	// Concurrency is the number of simultaneous requests, defaults to DefaultConcurrency
+	Concurrency int
+	// RequestsPerSecond caps the rate at which chunks are sent, zero means no cap
+	RequestsPerSecond float64
+	// DedupeKey derives the dedupe ID of every chunk along with its recipients, so that repeating a fan-out with
+	// the same key, recipients and ChunkSize does not send any chunk twice, while retrying FailedRecipients sends
+	// them anew. Changing the recipients or ChunkSize changes the chunks, which are then sent again. A random key
+	// is used when empty.
+	DedupeKey string
+}
+
+// ChunkResult is the outcome of sending the announcement to one chunk of recipients
+type ChunkResult struct {
+	Index          int
+	Recipients     []string
+	DedupeID       string
+	AnnouncementID string
+	Err            error
+}
+
+// FanoutResult aggregates the outcome of every chunk of a fan-out
+type FanoutResult struct {
+	Chunks []ChunkResult
+	// AnnouncementIDs lists the announcements created, in chunk order
+	AnnouncementIDs []string
+	// FailedRecipients lists the recipients of every failed chunk, to be retried
+	FailedRecipients []string
+}
+
+// FanoutAnnouncement sends an announcement to an arbitrarily large list of recipients by splitting it into
+// chunks sent concurrently under a request rate budget. An announcement to "everyone" is sent as is.
+// Failures of individual chunks are reported in the result, the error is only set when nothing could be sent, in
+// which case the result still lists the failed chunks.
+func (l *Layer) FanoutAnnouncement(req AnnouncementRequest, opts FanoutOptions) (FanoutResult, error) {
+	return fanout(req, opts, l.sendAnnouncement)
+}
+
+func fanout(req AnnouncementRequest, opts FanoutOptions, send func(AnnouncementRequest, *string) (AnnouncementResponse, error)) (FanoutResult, error) {
+	if err := validateRecipients(req.Recipients); err != nil {
+		return FanoutResult{}, err
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultAnnouncementChunkSize
+	}
+	if opts.DedupeKey == "" {
+		opts.DedupeKey, _ = newUUID()
+	}
+
+	chunks := [][]string{}
+	if containsString(req.Recipients, EveryoneRecipient) {
+		chunks = append(chunks, req.Recipients)
+	} else {
+		for i := 0; i < len(req.Recipients); i += opts.ChunkSize {
+			end := i + opts.ChunkSize
+			if end > len(req.Recipients) {
+				end = len(req.Recipients)
+			}
+			chunks = append(chunks, req.Recipients[i:end])
+		}
+	}
+
+	var ticks <-chan time.Time
+	if opts.RequestsPerSecond > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / opts.RequestsPerSecond))
+		defer t.Stop()
+		ticks = t.C
+	}
+
+	res := FanoutResult{Chunks: make([]ChunkResult, len(chunks)), AnnouncementIDs: []string{}, FailedRecipients: []string{}}
+	mu := sync.Mutex{}
+	parallel(len(chunks), opts.Concurrency, func(i int) {
+		if ticks != nil {
+			mu.Lock()
+			<-ticks
+			mu.Unlock()
+		}
+
+		c := ChunkResult{Index: i, Recipients: chunks[i], DedupeID: chunkDedupeID(opts.DedupeKey, chunks[i])}
+		r := req
+		r.Recipients = chunks[i]
+		ar, err := send(r, &c.DedupeID)
+		if e, ok := err.(*APIError); ok && e.StatusCode == http.StatusConflict {
+			// this chunk was already sent by an earlier fan-out with the same key
+			ar.ID, err = conflictID(e), nil
+		}
+		c.AnnouncementID, c.Err = ar.ID, err
+		res.Chunks[i] = c
+	})
+
+	for _, c := range res.Chunks {
+		if c.Err != nil {
+			res.FailedRecipients = append(res.FailedRecipients, c.Recipients...)
+			continue
+		}
+		res.AnnouncementIDs = append(res.AnnouncementIDs, c.AnnouncementID)
+	}
+	if len(res.AnnouncementIDs) == 0 {
+		return res, fmt.Errorf("All %d chunks failed: %w", len(chunks), res.Chunks[0].Err)
+	}
+	return res, nil
+}
+
+// chunkDedupeID derives a name based UUID for a chunk from the fan-out key and the chunk's recipients, in any order
+func chunkDedupeID(key string, recipients []string) string {
+	sorted := append([]string{}, recipients...)
+	sort.Strings(sorted)
+	h := sha1.New()
+	for _, r := range sorted {
+		h.Write([]byte(r))
+		h.Write([]byte{0})
+	}
+	return nameUUID(fmt.Sprintf("fanout/%s/%x", key, h.Sum(nil)))
+}
@@ -0,0 +1,101 @@
+package layer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAnnouncements mimics Layer's handling of dedupe IDs and fails the chunks starting with a given recipient once
+type fakeAnnouncements struct {
+	mu        sync.Mutex
+	dedupes   map[string]string
+	delivered []string
+	failOnce  map[string]bool
+}
+
+func (f *fakeAnnouncements) send(req AnnouncementRequest, dedupe *string) (AnnouncementResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.dedupes[*dedupe]; ok {
+		return AnnouncementResponse{}, &APIError{StatusCode: http.StatusConflict, Data: map[string]interface{}{"id": id}}
+	}
+	if f.failOnce[req.Recipients[0]] {
+		delete(f.failOnce, req.Recipients[0])
+		return AnnouncementResponse{}, &APIError{StatusCode: http.StatusServiceUnavailable}
+	}
+
+	id := "a-" + req.Recipients[0]
+	f.dedupes[*dedupe] = id
+	f.delivered = append(f.delivered, req.Recipients...)
+	return AnnouncementResponse{ID: id}, nil
+}
+
+func TestFanout(t *testing.T) {
+	recipients := []string{}
+	for i := 0; i < 25; i++ {
+		recipients = append(recipients, fmt.Sprintf("user%d", i))
+	}
+
+	f := &fakeAnnouncements{dedupes: map[string]string{}, failOnce: map[string]bool{"user10": true}}
+	opts := FanoutOptions{ChunkSize: 10, DedupeKey: "key"}
+	res, err := fanout(AnnouncementRequest{Recipients: recipients}, opts, f.send)
+	require.NoError(t, err)
+	require.Len(t, res.Chunks, 3)
+	require.Equal(t, []string{"a-user0", "a-user20"}, res.AnnouncementIDs)
+	require.Equal(t, recipients[10:20], res.FailedRecipients)
+	require.Error(t, res.Chunks[1].Err)
+	require.Len(t, f.delivered, 15)
+
+	// retrying only the failed recipients with the same key actually sends to them
+	retry, err := fanout(AnnouncementRequest{Recipients: res.FailedRecipients}, opts, f.send)
+	require.NoError(t, err)
+	require.Empty(t, retry.FailedRecipients)
+	require.Equal(t, []string{"a-user10"}, retry.AnnouncementIDs)
+	require.Len(t, f.delivered, 25)
+	require.Equal(t, recipients[10:20], f.delivered[15:])
+
+	// repeating the whole fan-out with the same key sends nothing twice
+	again, err := fanout(AnnouncementRequest{Recipients: recipients}, opts, f.send)
+	require.NoError(t, err)
+	require.Equal(t, res.Chunks[0].DedupeID, again.Chunks[0].DedupeID)
+	require.Equal(t, []string{"a-user0", "a-user10", "a-user20"}, again.AnnouncementIDs)
+	require.Len(t, f.delivered, 25)
+
+	res, err = fanout(AnnouncementRequest{Recipients: []string{"everyone"}}, FanoutOptions{ChunkSize: 1}, f.send)
+	require.NoError(t, err)
+	require.Len(t, res.Chunks, 1)
+
+	_, err = fanout(AnnouncementRequest{}, opts, f.send)
+	require.Equal(t, ErrEmptyAudience, err)
+	_, err = fanout(AnnouncementRequest{Recipients: []string{"user1", EveryoneRecipient}}, opts, f.send)
+	require.Equal(t, ErrMixedAudience, err)
+}
+
+func TestFanoutRate(t *testing.T) {
+	errUnused := errors.New("unused")
+	send := func(req AnnouncementRequest, dedupe *string) (AnnouncementResponse, error) {
+		return AnnouncementResponse{}, errUnused
+	}
+
+	start := time.Now()
+	res, err := fanout(AnnouncementRequest{Recipients: []string{"a", "b", "c", "d"}}, FanoutOptions{ChunkSize: 1, RequestsPerSecond: 50}, send)
+	require.True(t, errors.Is(err, errUnused))
+	require.True(t, time.Since(start) >= 70*time.Millisecond)
+	require.Equal(t, []string{"a", "b", "c", "d"}, res.FailedRecipients)
+}
+
+func TestChunkDedupeID(t *testing.T) {
+	id := chunkDedupeID("key", []string{"a", "b"})
+	require.Len(t, id, 36)
+	require.Equal(t, id, chunkDedupeID("key", []string{"b", "a"}))
+	require.NotEqual(t, id, chunkDedupeID("key", []string{"a"}))
+	require.NotEqual(t, id, chunkDedupeID("key", []string{"ab"}))
+	require.NotEqual(t, id, chunkDedupeID("other", []string{"a", "b"}))
+	require.Equal(t, byte('5'), id[14])
+}
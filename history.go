@@ -0,0 +1,260 @@
+package layer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HistoryOrder is the direction in which a MessageIterator walks a conversation's history
+type HistoryOrder int
+
+const (
+	// NewestFirst walks from the most recent message back to the first one
+	NewestFirst HistoryOrder = iota
+	// OldestFirst walks from the first message forward to the most recent one
+	OldestFirst
+)
+
+// AnomalyKind describes an inconsistency detected between two consecutive messages of a history
+type AnomalyKind string
+
+const (
+	// AnomalyGap means the positions of two consecutive messages are further apart than the gap threshold
+	AnomalyGap AnomalyKind = "gap"
+	// AnomalyReorder means a message's position is out of order with respect to the previous message
+	AnomalyReorder AnomalyKind = "reorder"
+	// AnomalyDuplicate means a message was returned twice, the second copy is skipped
+	AnomalyDuplicate AnomalyKind = "duplicate"
+)
+
+var (
+	// ErrCursorNotFound is returned when the message a MessageIterator should resume from is not in the history
+	ErrCursorNotFound = errors.New("Cursor Message Not Found")
+)
+
+// HistoryOptions configures a MessageIterator
+type HistoryOptions struct {
+	// UserID reads the history from that user's perspective when set, otherwise from the System's
+	UserID string
+	Order  HistoryOrder
+	// FromID resumes iteration after this message, typically the Cursor of a previous iterator
+	FromID string
+	// PageSize is the number of messages decoded per request, defaults to 100
+	PageSize int
+	// GapThreshold reports a gap when consecutive positions differ by more than it, zero disables gap detection
+	GapThreshold int64
+}
+
+// HistoryAnomaly is a gap or ordering problem detected between the Previous and the Current message
+type HistoryAnomaly struct {
+	Kind     AnomalyKind
+	Previous MessageResponse
+	Current  MessageResponse
+}
+
+// MessageIterator streams a conversation's messages one page at a time. Call Next until it returns false,
+// then check Err.
+type MessageIterator struct {
+	fetch func(params *QueryParameters) ([]MessageResponse, error)
+	opts  HistoryOptions
+
+	page    []MessageResponse
+	idx     int
+	cursors []string
+	newest  []MessageResponse
+	started bool
+	done    bool
+
+	resumePos int64
+	current   MessageResponse
+	prev      *MessageResponse
+	anomalies []HistoryAnomaly
+	err       error
+}
+
+// IterateMessages returns an iterator over the messages of a conversation
+func (l *Layer) IterateMessages(convID string, opts HistoryOptions) *MessageIterator {
+	path := fmt.Sprintf("conversations/%s/messages", convID)
+	if opts.UserID != "" {
+		path = fmt.Sprintf("users/%s/conversations/%s/messages", opts.UserID, convID)
+	}
+
+	return newMessageIterator(func(params *QueryParameters) ([]MessageResponse, error) {
+		return l.getMessages(path, params)
+	}, opts)
+}
+
+func newMessageIterator(fetch func(*QueryParameters) ([]MessageResponse, error), opts HistoryOptions) *MessageIterator {
+	if opts.PageSize <= 0 || opts.PageSize > maxPageSize {
+		opts.PageSize = maxPageSize
+	}
+	return &MessageIterator{fetch: fetch, opts: opts}
+}
+
+// Next advances to the next message, returning false when the history is exhausted or an error occurred
+func (it *MessageIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if !it.started {
+		it.started = true
+		if it.opts.Order == OldestFirst {
+			it.err = it.collectCursors()
+		} else {
+			it.err = it.loadNewest(it.opts.FromID)
+		}
+		if it.err != nil {
+			return false
+		}
+	}
+
+	for {
+		for it.idx < len(it.page) {
+			m := it.page[it.idx]
+			it.idx++
+			if it.opts.Order == OldestFirst && it.opts.FromID != "" && m.Position <= it.resumePos {
+				continue
+			}
+			if it.check(m) {
+				it.current = m
+				return true
+			}
+		}
+
+		if it.done {
+			return false
+		}
+		if it.opts.Order == OldestFirst {
+			it.err = it.loadNewer()
+		} else {
+			it.err = it.loadNewest(it.page[len(it.page)-1].GetID())
+		}
+		if it.err != nil {
+			return false
+		}
+	}
+}
+
+// Message returns the current message
+func (it *MessageIterator) Message() MessageResponse {
+	return it.current
+}
+
+// Cursor returns the ID of the current message, from which a new iterator with the same order can resume
+func (it *MessageIterator) Cursor() string {
+	return it.current.GetID()
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *MessageIterator) Err() error {
+	return it.err
+}
+
+// Anomalies returns the gaps, reorderings and duplicates detected so far
+func (it *MessageIterator) Anomalies() []HistoryAnomaly {
+	return it.anomalies
+}
+
+// check records any anomaly between the previous message and m, returning false if m must be skipped
+func (it *MessageIterator) check(m MessageResponse) bool {
+	if it.prev == nil {
+		it.prev = &m
+		return true
+	}
+
+	p := *it.prev
+	if p.ID == m.ID {
+		it.anomalies = append(it.anomalies, HistoryAnomaly{Kind: AnomalyDuplicate, Previous: p, Current: m})
+		return false
+	}
+
+	step := m.Position - p.Position
+	if it.opts.Order == NewestFirst {
+		step = -step
+	}
+	switch {
+	case step <= 0:
+		it.anomalies = append(it.anomalies, HistoryAnomaly{Kind: AnomalyReorder, Previous: p, Current: m})
+	case it.opts.GapThreshold > 0 && step > it.opts.GapThreshold:
+		it.anomalies = append(it.anomalies, HistoryAnomaly{Kind: AnomalyGap, Previous: p, Current: m})
+	}
+
+	it.prev = &m
+	return true
+}
+
+// loadNewest fetches the page of messages older than fromID, or the most recent page when fromID is empty
+func (it *MessageIterator) loadNewest(fromID string) error {
+	page, err := it.fetch(&QueryParameters{PageSize: it.opts.PageSize, FromID: fromID})
+	if err != nil {
+		return err
+	}
+
+	it.page, it.idx = page, 0
+	it.done = len(page) < it.opts.PageSize
+	return nil
+}
+
+// collectCursors walks the history newest first, keeping only the cursor of each page so that pages can then
+// be replayed oldest first. The oldest page is replayed straight away, and the newest page is kept so that
+// messages sent during the iteration cannot push part of it out of reach.
+func (it *MessageIterator) collectCursors() error {
+	cursor := ""
+	for {
+		page, err := it.fetch(&QueryParameters{PageSize: it.opts.PageSize, FromID: cursor})
+		if err != nil {
+			return err
+		}
+		it.cursors = append(it.cursors, cursor)
+
+		found := false
+		if it.opts.FromID != "" {
+			for _, m := range page {
+				if m.GetID() == it.opts.FromID {
+					it.resumePos, found = m.Position, true
+				}
+			}
+		}
+
+		if found || len(page) < it.opts.PageSize {
+			if it.opts.FromID != "" && !found {
+				return ErrCursorNotFound
+			}
+			it.cursors = it.cursors[:len(it.cursors)-1]
+			it.setPage(page)
+			return nil
+		}
+		if cursor == "" {
+			it.newest = page
+		}
+		cursor = page[len(page)-1].GetID()
+	}
+}
+
+// loadNewer fetches the next page to replay in OldestFirst order
+func (it *MessageIterator) loadNewer() error {
+	cursor := it.cursors[len(it.cursors)-1]
+	it.cursors = it.cursors[:len(it.cursors)-1]
+
+	if cursor == "" {
+		it.setPage(it.newest)
+		return nil
+	}
+
+	page, err := it.fetch(&QueryParameters{PageSize: it.opts.PageSize, FromID: cursor})
+	if err != nil {
+		return err
+	}
+	it.setPage(page)
+	return nil
+}
+
+// setPage reverses a page into OldestFirst order
+func (it *MessageIterator) setPage(page []MessageResponse) {
+	for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+		page[i], page[j] = page[j], page[i]
+	}
+	it.page, it.idx = page, 0
+	it.done = len(it.cursors) == 0
+}
@@ -0,0 +1,129 @@
+package layer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHistory serves messages, stored oldest first, newest first a page at a time like the messages endpoint
+func fakeHistory(messages []MessageResponse, requests *int) func(*QueryParameters) ([]MessageResponse, error) {
+	return func(q *QueryParameters) ([]MessageResponse, error) {
+		*requests++
+		end := len(messages)
+		if q.FromID != "" {
+			end = -1
+			for i, m := range messages {
+				if m.GetID() == q.FromID {
+					end = i
+				}
+			}
+			if end < 0 {
+				return nil, &APIError{StatusCode: 404, ID: "not_found"}
+			}
+		}
+
+		page := []MessageResponse{}
+		for i := end - 1; i >= 0 && len(page) < q.PageSize; i-- {
+			page = append(page, messages[i])
+		}
+		return page, nil
+	}
+}
+
+func buildHistory(positions ...int64) []MessageResponse {
+	messages := []MessageResponse{}
+	for i, p := range positions {
+		messages = append(messages, MessageResponse{ID: fmt.Sprintf("%s%d", msgHead, i), Position: p})
+	}
+	return messages
+}
+
+func collect(it *MessageIterator) []string {
+	ids := []string{}
+	for it.Next() {
+		ids = append(ids, it.Cursor())
+	}
+	return ids
+}
+
+func TestMessageIteratorNewestFirst(t *testing.T) {
+	requests := 0
+	history := buildHistory(1, 2, 3, 4, 5, 6, 7)
+	it := newMessageIterator(fakeHistory(history, &requests), HistoryOptions{PageSize: 3})
+
+	require.Equal(t, []string{"6", "5", "4", "3", "2", "1", "0"}, collect(it))
+	require.NoError(t, it.Err())
+	require.Len(t, it.Anomalies(), 0)
+	require.Equal(t, 3, requests)
+
+	it = newMessageIterator(fakeHistory(history, &requests), HistoryOptions{PageSize: 3, FromID: "4"})
+	require.Equal(t, []string{"3", "2", "1", "0"}, collect(it))
+}
+
+func TestMessageIteratorOldestFirst(t *testing.T) {
+	requests := 0
+	history := buildHistory(1, 2, 3, 4, 5, 6, 7)
+	it := newMessageIterator(fakeHistory(history, &requests), HistoryOptions{PageSize: 3, Order: OldestFirst})
+
+	require.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6"}, collect(it))
+	require.NoError(t, it.Err())
+	require.Equal(t, 4, requests)
+
+	it = newMessageIterator(fakeHistory(history, &requests), HistoryOptions{PageSize: 3, Order: OldestFirst, FromID: "2"})
+	require.Equal(t, []string{"3", "4", "5", "6"}, collect(it))
+
+	it = newMessageIterator(fakeHistory(history, &requests), HistoryOptions{PageSize: 3, Order: OldestFirst, FromID: "missing"})
+	require.False(t, it.Next())
+	require.Equal(t, ErrCursorNotFound, it.Err())
+}
+
+func TestMessageIteratorAnomalies(t *testing.T) {
+	requests := 0
+	history := buildHistory(1, 2, 10, 9, 11)
+	it := newMessageIterator(fakeHistory(history, &requests), HistoryOptions{Order: OldestFirst, GapThreshold: 5})
+
+	require.Len(t, collect(it), 5)
+	a := it.Anomalies()
+	require.Len(t, a, 2)
+	require.Equal(t, AnomalyGap, a[0].Kind)
+	require.Equal(t, int64(2), a[0].Previous.Position)
+	require.Equal(t, int64(10), a[0].Current.Position)
+	require.Equal(t, AnomalyReorder, a[1].Kind)
+	require.Equal(t, int64(9), a[1].Current.Position)
+}
+
+func TestMessageIteratorError(t *testing.T) {
+	it := newMessageIterator(func(*QueryParameters) ([]MessageResponse, error) {
+		return nil, &APIError{StatusCode: 500}
+	}, HistoryOptions{})
+
+	require.False(t, it.Next())
+	require.Error(t, it.Err())
+	require.False(t, it.Next())
+}
+
+func TestIterateMessages(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	for _, body := range []string{"first", "second", "third"} {
+		_, err := l.SendMessage(convID, user1, []Parts{TextPart(body)}, Notification{})
+		require.NoError(t, err)
+	}
+
+	it := l.IterateMessages(convID, HistoryOptions{UserID: user2, Order: OldestFirst, PageSize: 2})
+	bodies := []string{}
+	for it.Next() {
+		bodies = append(bodies, it.Message().Parts[0].Body)
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"first", "second", "third"}, bodies)
+	require.Len(t, it.Anomalies(), 0)
+}
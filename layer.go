@@ -2,9 +2,12 @@ package layer
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -19,6 +22,10 @@ var (
 	ErrMissingUserID = errors.New("Missing UserID")
 	// ErrEmptyParticipants __
 	ErrEmptyParticipants = errors.New("Empty Participants")
+	// ErrInvalidSender is returned when a message sender does not have exactly one of a user ID or a name
+	ErrInvalidSender = errors.New("Sender Must Have Exactly One Of UserID Or Name")
+	// ErrInvalidDeletionMode is returned when a deletion mode other than the DeleteFor constants is given
+	ErrInvalidDeletionMode = errors.New("Invalid Deletion Mode")
 )
 
 // Layer is an instance of a layer api object
@@ -29,11 +36,35 @@ type Layer struct {
 	timeout time.Duration
 }
 
-// Parameters contains the options passed in from the caller of request
+// APIError is the error body returned by Layer when a request fails
+type APIError struct {
+	StatusCode int         `json:"-"`
+	ID         string      `json:"id"`
+	Code       int         `json:"code"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Responded with Error Code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Responded with Error Code %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried, i.e. it was rate limited or failed server side
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Parameters contains the options passed in from the caller of request.
+// URL replaces the app relative Path for endpoints Layer returns as absolute URLs.
 type Parameters struct {
-	Dedupe *string
-	Path   string
-	Body   []byte
+	Dedupe  *string
+	Path    string
+	URL     string
+	Body    []byte
+	Headers map[string]string
 }
 
 // QueryParameters contains the possible query parameters to add onto a layer API call
@@ -43,6 +74,29 @@ type QueryParameters struct {
 	SortBy   string
 }
 
+// encode returns the query string for the parameters, including the leading '?'
+func (q *QueryParameters) encode() string {
+	if q == nil {
+		return ""
+	}
+
+	v := url.Values{}
+	if q.PageSize > 0 {
+		v.Set("page_size", strconv.Itoa(q.PageSize))
+	}
+	if q.FromID != "" {
+		v.Set("from_id", q.FromID)
+	}
+	if q.SortBy != "" {
+		v.Set("sort_by", q.SortBy)
+	}
+
+	if len(v) == 0 {
+		return ""
+	}
+	return "?" + v.Encode()
+}
+
 // NewLayer returns a new instance of a Layer struct
 func NewLayer(token, appID, version string, timeout time.Duration) *Layer {
 	return &Layer{
@@ -57,7 +111,12 @@ func (l *Layer) request(method string, p *Parameters) (*http.Response, error) {
 	method = strings.ToUpper(method)
 	client := http.Client{Timeout: l.timeout}
 
-	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s/%s/%s", base, prefix, l.appID, p.Path), bytes.NewBuffer(p.Body))
+	u := p.URL
+	if u == "" {
+		u = fmt.Sprintf("%s/%s/%s/%s", base, prefix, l.appID, p.Path)
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewBuffer(p.Body))
 	if err != nil {
 		return nil, err
 	}
@@ -72,9 +131,25 @@ func (l *Layer) request(method string, p *Parameters) (*http.Response, error) {
 		req.Header.Set("If-None-Match", *p.Dedupe)
 	}
 
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+
 	req.Header.Set("Accept", fmt.Sprintf("application/vnd.layer+json; version=%s", l.version))
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", l.token))
 
 	return client.Do(req)
 
 }
+
+// checkResponse returns an *APIError built from the response body when the status is not a success
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	e := APIError{}
+	json.NewDecoder(resp.Body).Decode(&e)
+	e.StatusCode = resp.StatusCode
+	return &e
+}
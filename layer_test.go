@@ -0,0 +1,34 @@
+package layer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.WriteString(`{"id":"rate_limit_exceeded","code":110,"message":"Too many requests"}`)
+
+	err := checkResponse(w.Result())
+	require.Error(t, err)
+	e, ok := err.(*APIError)
+	require.True(t, ok)
+	require.Equal(t, "rate_limit_exceeded", e.ID)
+	require.Equal(t, 110, e.Code)
+	require.True(t, e.Temporary())
+
+	w = httptest.NewRecorder()
+	w.WriteHeader(http.StatusCreated)
+	require.NoError(t, checkResponse(w.Result()))
+}
+
+func TestQueryParametersEncode(t *testing.T) {
+	var q *QueryParameters
+	require.Equal(t, "", q.encode())
+	require.Equal(t, "", (&QueryParameters{}).encode())
+	require.Equal(t, "?from_id=abc&page_size=10&sort_by=created_at", (&QueryParameters{PageSize: 10, FromID: "abc", SortBy: SortByCreated}).encode())
+}
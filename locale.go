@@ -0,0 +1,120 @@
+package layer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrMissingLocale is returned when a localized notification has no text for its default locale
+	ErrMissingLocale = errors.New("Missing Default Locale")
+)
+
+// LocaleResolver maps a user to their locale, e.g. "pt-BR". An empty locale means the user's locale is unknown.
+type LocaleResolver interface {
+	Locale(userID string) (string, error)
+}
+
+// LocaleMap is a LocaleResolver backed by a map of user IDs to locales
+type LocaleMap map[string]string
+
+// Locale returns the locale of the user, or an empty string if the user is not in the map
+func (m LocaleMap) Locale(userID string) (string, error) {
+	return m[userID], nil
+}
+
+// LocalizedNotification holds the notification to send in every supported locale. A recipient gets the
+// notification of their locale, else of its base language ("pt" for "pt-BR"), else of the Default locale.
+// Locale entries inherit the sound and platform options of the default entry when they have none of their own.
+type LocalizedNotification struct {
+	Default string
+	Locales map[string]Notification
+}
+
+// Resolve expands the localized notification into a Notification with an override for every recipient not
+// using the default locale. A nil recipients list, as for announcements sent to everyone, yields the default.
+func (ln LocalizedNotification) Resolve(recipients []string, r LocaleResolver) (Notification, error) {
+	locales := map[string]Notification{}
+	for loc, n := range ln.Locales {
+		locales[normalizeLocale(loc)] = n
+	}
+	def := normalizeLocale(ln.Default)
+	base, ok := locales[def]
+	if !ok {
+		return Notification{}, fmt.Errorf("%w: %q", ErrMissingLocale, ln.Default)
+	}
+
+	b := NewNotificationBuilder(base)
+	for _, u := range recipients {
+		userLocale, err := r.Locale(u)
+		if err != nil {
+			return Notification{}, fmt.Errorf("resolving locale of %s: %w", u, err)
+		}
+		loc := matchLocale(normalizeLocale(userLocale), locales)
+		if loc == "" || loc == def {
+			continue
+		}
+
+		n := locales[loc]
+		if n.Sound == "" {
+			n.Sound = base.Sound
+		}
+		if n.APNS == nil {
+			n.APNS = base.APNS
+		}
+		if n.Android == nil {
+			n.Android = base.Android
+		}
+		b.Override(n, u)
+	}
+	return b.Build(nil)
+}
+
+// SendLocalizedMessage sends a message whose notification is localized for every participant of the conversation
+func (l *Layer) SendLocalizedMessage(convID, sender string, parts []Parts, ln LocalizedNotification, r LocaleResolver) (MessageResponse, error) {
+	c, err := l.GetConversation(convID)
+	if err != nil {
+		return MessageResponse{}, err
+	}
+	n, err := ln.Resolve(c.Participants, r)
+	if err != nil {
+		return MessageResponse{}, err
+	}
+	return l.SendMessage(convID, sender, parts, n)
+}
+
+// SendLocalizedAnnouncement sends an announcement whose notification is localized for every recipient. The
+// Notification of req is replaced. Announcements to everyone get the notification of the default locale.
+func (l *Layer) SendLocalizedAnnouncement(req AnnouncementRequest, ln LocalizedNotification, r LocaleResolver) (AnnouncementResponse, error) {
+	recipients := req.Recipients
+	if containsString(recipients, EveryoneRecipient) {
+		recipients = nil
+	}
+	n, err := ln.Resolve(recipients, r)
+	if err != nil {
+		return AnnouncementResponse{}, err
+	}
+	req.Notification = n
+	return l.SendAnnouncement(req)
+}
+
+// normalizeLocale lower cases a locale and uses hyphens as separator, so "pt_BR" and "pt-br" are the same
+func normalizeLocale(loc string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(loc), "_", "-", -1))
+}
+
+// matchLocale returns the most specific prefix of loc available in locales, or an empty string
+func matchLocale(loc string, locales map[string]Notification) string {
+	for loc != "" {
+		if _, ok := locales[loc]; ok {
+			return loc
+		}
+		i := strings.LastIndex(loc, "-")
+		if i < 0 {
+			return ""
+		}
+		loc = loc[:i]
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package layer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type failingResolver struct{}
+
+func (failingResolver) Locale(userID string) (string, error) {
+	return "", errors.New("lookup failed")
+}
+
+func TestLocalizedNotificationResolve(t *testing.T) {
+	ln := LocalizedNotification{
+		Default: "en",
+		Locales: map[string]Notification{
+			"en":    Notification{Text: "New message", Sound: "chime.aiff", APNS: &APNSOptions{Category: "reply"}},
+			"pt":    Notification{Text: "Nova mensagem"},
+			"pt-BR": Notification{Text: "Nova mensagem!", Sound: "samba.aiff"},
+			"fr":    Notification{Text: "Nouveau message"},
+		},
+	}
+	resolver := LocaleMap{"en": "en-US", "br": "pt_BR", "pt": "pt-PT", "fr": "FR", "de": "de-DE", "none": ""}
+
+	n, err := ln.Resolve([]string{"en", "br", "pt", "fr", "de", "none"}, resolver)
+	require.NoError(t, err)
+	require.Equal(t, "New message", n.Text)
+	require.Len(t, n.Recipients, 3)
+	require.Equal(t, "Nova mensagem!", n.Recipients["br"].Text)
+	require.Equal(t, "samba.aiff", n.Recipients["br"].Sound)
+	require.Equal(t, "Nova mensagem", n.Recipients["pt"].Text)
+	require.Equal(t, "chime.aiff", n.Recipients["pt"].Sound)
+	require.Equal(t, "reply", n.Recipients["fr"].APNS.Category)
+
+	n, err = ln.Resolve(nil, resolver)
+	require.NoError(t, err)
+	require.Nil(t, n.Recipients)
+
+	_, err = ln.Resolve([]string{"en"}, failingResolver{})
+	require.Error(t, err)
+
+	_, err = LocalizedNotification{Default: "es", Locales: ln.Locales}.Resolve(nil, resolver)
+	require.True(t, errors.Is(err, ErrMissingLocale))
+}
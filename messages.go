@@ -3,20 +3,31 @@ package layer
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
+var (
+	msgHead = "layer:///messages/"
+)
+
 // MessageResponse is the struct containing fields returned from a successful message response
 type MessageResponse struct {
-	ID              string            `json:"id,omitempty"`
-	URL             string            `json:"url,omitempty"`
-	Conversation    Conversation      `json:"conversation,omitempty"`
-	Parts           []Parts           `json:"parts,omitempty"`
-	SentAt          time.Time         `json:"sent_at,omitempty"`
-	Sender          Sender            `json:"sender,omitempty"`
-	RecipientStatus map[string]string `json:"recipient_status,omitempty"`
-	IsUnread        bool              `json:"is_unread,omitempty"`
-	Recieved        time.Time         `json:"received_at,omitempty"`
+	ID              string          `json:"id,omitempty"`
+	URL             string          `json:"url,omitempty"`
+	Position        int64           `json:"position,omitempty"`
+	Conversation    Conversation    `json:"conversation,omitempty"`
+	Parts           []Parts         `json:"parts,omitempty"`
+	SentAt          time.Time       `json:"sent_at,omitempty"`
+	Sender          Sender          `json:"sender,omitempty"`
+	RecipientStatus RecipientStatus `json:"recipient_status,omitempty"`
+	IsUnread        bool            `json:"is_unread,omitempty"`
+	Received        time.Time       `json:"received_at,omitempty"`
+}
+
+// SenderKind returns whether the message was sent by a participant or by the system
+func (m MessageResponse) SenderKind() SenderKind {
+	return m.Sender.Kind()
 }
 
 // MessageRequest contains the response from a message request
@@ -26,61 +37,86 @@ type MessageRequest struct {
 	Notification Notification `json:"notification,omitempty"`
 }
 
+// GetID returns the message ID from a message response object
+func (m MessageResponse) GetID() string {
+	return strings.Replace(m.ID, msgHead, "", -1)
+}
+
 // SendMessage creates a new message in a conversation
 func (l *Layer) SendMessage(convID string, sender string, parts []Parts, n Notification) (MessageResponse, error) {
-	b := MessageRequest{Sender: Sender{UserID: sender}, Parts: parts, Notification: n}
+	return l.SendMessageAs(convID, Sender{UserID: sender}, parts, n)
+}
+
+// SendMessageAs creates a new message in a conversation from either a participant, identified by UserID, or
+// from the system under a display Name such as "Order Bot". Exactly one of the two must be set.
+func (l *Layer) SendMessageAs(convID string, sender Sender, parts []Parts, n Notification) (MessageResponse, error) {
+	return l.sendMessage(convID, sender, parts, n, nil)
+}
+
+// sendMessage creates a message, letting Layer discard it as a duplicate when dedupe was already used
+func (l *Layer) sendMessage(convID string, sender Sender, parts []Parts, n Notification, dedupe *string) (MessageResponse, error) {
+	if (sender.UserID == "") == (sender.Name == "") {
+		return MessageResponse{}, ErrInvalidSender
+	}
+	if err := n.Validate(); err != nil {
+		return MessageResponse{}, err
+	}
+
+	b := MessageRequest{Sender: Sender{UserID: sender.UserID, Name: sender.Name}, Parts: parts, Notification: n}
 	body, err := json.Marshal(&b)
 	if err != nil {
 		return MessageResponse{}, err
 	}
-	p := Parameters{Path: fmt.Sprintf("conversations/%s/messages", convID), Body: body}
+	p := Parameters{Path: fmt.Sprintf("conversations/%s/messages", convID), Body: body, Dedupe: dedupe}
 	resp, err := l.request("POST", &p)
 	if err != nil {
 		return MessageResponse{}, err
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return MessageResponse{}, err
+	}
+
 	m := MessageResponse{}
-	json.NewDecoder(resp.Body).Decode(&m)
+	l.decode(resp.Body, &m)
 	return m, err
 }
 
-// UploadRichContent allows messages whose body is larger than 2KB to be sent. Must be called prior to sending
-// message with rich content
-// TODO
-func UploadRichContent() {}
-
-// SendMessageWithRichContent will send a Message that includes the Rich Content part of a messgae
-// once the Rich Content upload has completed from a send message request
-// TODO
-func SendMessageWithRichContent() {}
-
-// GetMessagesForUser requests all messages in a conversation from a specific user's perspective
-func (l *Layer) GetMessagesForUser(convID, userID string) ([]MessageResponse, error) {
-	p := Parameters{Path: fmt.Sprintf("users/%s/conversations/%s/messages", userID, convID)}
-	resp, err := l.request("GET", &p)
+// SendMessageWithRichContent creates a new message in a conversation like SendMessage, except that any part whose
+// body exceeds MaxInlineBodySize is first uploaded as rich content and sent as a reference to that content
+func (l *Layer) SendMessageWithRichContent(convID string, sender string, parts []Parts, n Notification) (MessageResponse, error) {
+	parts, err := l.uploadLargeParts(convID, parts)
 	if err != nil {
-		return []MessageResponse{}, err
+		return MessageResponse{}, err
 	}
-	defer resp.Body.Close()
-
-	m := []MessageResponse{}
-	json.NewDecoder(resp.Body).Decode(&m)
+	return l.SendMessage(convID, sender, parts, n)
+}
 
-	return m, err
+// GetMessagesForUser requests all messages in a conversation from a specific user's perspective
+func (l *Layer) GetMessagesForUser(convID, userID string) ([]MessageResponse, error) {
+	return l.getMessages(fmt.Sprintf("users/%s/conversations/%s/messages", userID, convID), nil)
 }
 
 // GetAllMessages requests all messages in a conversation from the System's perspective
 func (l *Layer) GetAllMessages(convID string) ([]MessageResponse, error) {
-	p := Parameters{Path: fmt.Sprintf("conversations/%s/messages", convID)}
+	return l.getMessages(fmt.Sprintf("conversations/%s/messages", convID), nil)
+}
+
+func (l *Layer) getMessages(path string, params *QueryParameters) ([]MessageResponse, error) {
+	p := Parameters{Path: path + params.encode()}
 	resp, err := l.request("GET", &p)
 	if err != nil {
 		return []MessageResponse{}, err
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return []MessageResponse{}, err
+	}
+
 	m := []MessageResponse{}
-	json.NewDecoder(resp.Body).Decode(&m)
+	l.decode(resp.Body, &m)
 	return m, err
 }
 
@@ -94,8 +130,12 @@ func (l *Layer) GetMessageForUser(userID, messageID string) (MessageResponse, er
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return MessageResponse{}, err
+	}
+
 	m := MessageResponse{}
-	json.NewDecoder(resp.Body).Decode(&m)
+	l.decode(resp.Body, &m)
 	return m, err
 }
 
@@ -109,11 +149,24 @@ func (l *Layer) GetMessage(convID, msgID string) (MessageResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return MessageResponse{}, err
+	}
+
 	m := MessageResponse{}
-	json.NewDecoder(resp.Body).Decode(&m)
+	l.decode(resp.Body, &m)
 	return m, err
 }
 
+// DeleteMessageForUser deletes a message from a specific user's perspective, either for that user alone or
+// for all recipients
+func (l *Layer) DeleteMessageForUser(userID, msgID string, mode DeletionMode) (ok bool, err error) {
+	if userID == "" {
+		return false, ErrMissingUserID
+	}
+	return l.deleteWithMode(fmt.Sprintf("users/%s/messages/%s", userID, msgID), mode, false)
+}
+
 // DeleteMessage causes the message to be destroyed for all recipients.
 func (l *Layer) DeleteMessage(convID, msgID string) (ok bool, err error) {
 	p := Parameters{Path: fmt.Sprintf("conversations/%s/messages/%s", convID, msgID)}
@@ -124,8 +177,8 @@ func (l *Layer) DeleteMessage(convID, msgID string) (ok bool, err error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 204 {
-		return false, fmt.Errorf("Responded with Error Code %d", resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		return false, err
 	}
 
 	return true, nil
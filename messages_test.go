@@ -1,6 +1,7 @@
 package layer
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 
@@ -8,10 +9,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-var (
-	msgHead = "layer:///messages/"
-)
-
 func TestSendMessage(t *testing.T) {
 	user1 := uuid.New()
 	user2 := uuid.New()
@@ -41,6 +38,53 @@ func TestSendMessage(t *testing.T) {
 	require.Equal(t, convID, getConvID(res3[0].Conversation.ID))
 }
 
+func TestSendMessageWithRichContent(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	small := Parts{Body: "Hello World", MimeType: "text/plain"}
+	large := Parts{Body: strings.Repeat("a", MaxInlineBodySize+1), MimeType: "text/plain"}
+	res2, err := l.SendMessageWithRichContent(convID, user1, []Parts{small, large}, Notification{})
+	require.NoError(t, err)
+	require.Len(t, res2.Parts, 2)
+	require.Equal(t, small.Body, res2.Parts[0].Body)
+	require.NotNil(t, res2.Parts[1].Content)
+	require.Equal(t, int64(len(large.Body)), res2.Parts[1].Content.Size)
+}
+
+func TestSendMessageAs(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	res2, err := l.SendMessageAs(convID, Sender{Name: "Order Bot"}, []Parts{TextPart("Your order shipped")}, Notification{})
+	require.NoError(t, err)
+	require.Equal(t, "Order Bot", res2.Sender.Name)
+	require.Equal(t, SenderKindSystem, res2.SenderKind())
+
+	res3, err := l.SendMessageAs(convID, Sender{UserID: user1}, []Parts{TextPart("Thanks")}, Notification{})
+	require.NoError(t, err)
+	require.Equal(t, SenderKindUser, res3.SenderKind())
+}
+
+func TestSendMessageAsInvalidSender(t *testing.T) {
+	_, err := l.SendMessageAs("conv", Sender{}, []Parts{TextPart("Hello")}, Notification{})
+	require.Equal(t, ErrInvalidSender, err)
+
+	_, err = l.SendMessageAs("conv", Sender{UserID: "user1", Name: "Order Bot"}, []Parts{TextPart("Hello")}, Notification{})
+	require.Equal(t, ErrInvalidSender, err)
+
+	_, err = l.SendMessage("conv", "", []Parts{TextPart("Hello")}, Notification{})
+	require.Equal(t, ErrInvalidSender, err)
+}
+
 func TestGetMessagesForUser(t *testing.T) {
 	user1 := uuid.New()
 	user2 := uuid.New()
@@ -165,6 +209,53 @@ func TestDeleteMessage(t *testing.T) {
 	require.Equal(t, "not_found", res5.ID)
 }
 
+func TestDeleteMessageForUser(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	p := Parts{
+		Body:     "Hello World",
+		MimeType: "text/plain",
+	}
+	res2, err := l.SendMessage(convID, user1, []Parts{p}, Notification{})
+	require.NoError(t, err)
+	msgID := res2.GetID()
+
+	ok, err := l.DeleteMessageForUser(user2, msgID, DeleteForMyDevices)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	res3, err := l.GetMessageForUser(user1, msgID)
+	require.NoError(t, err)
+	require.Equal(t, p.Body, res3.Parts[0].Body)
+}
+
+func TestDecodeMessageV1(t *testing.T) {
+	m := MessageResponse{}
+	require.NoError(t, json.Unmarshal(loadFixture(t, "message_v1.json"), &m))
+
+	require.Equal(t, "940de862-3c96-11e4-baad-164230d1df67", m.GetID())
+	require.Equal(t, "t-bone", m.Sender.Name)
+	require.Equal(t, "", m.Sender.UserID)
+	require.True(t, m.IsUnread)
+	require.False(t, m.Received.IsZero())
+	require.Equal(t, RecipientStatus{"777": "sent", "999": "read", "111": "delivered"}, m.RecipientStatus)
+}
+
+func TestDecodeMessageV2(t *testing.T) {
+	m := MessageResponse{}
+	require.NoError(t, json.Unmarshal(loadFixture(t, "message_v2.json"), &m))
+
+	require.Equal(t, "777", m.Sender.UserID)
+	require.Equal(t, "Seven", m.Sender.DisplayName)
+	require.Equal(t, int64(15032697020), m.Position)
+	require.Equal(t, RecipientStatus{"777": "read", "999": "delivered"}, m.RecipientStatus)
+}
+
 func getMessageID(s string) string {
 	return strings.Replace(s, msgHead, "", -1)
 }
@@ -0,0 +1,117 @@
+package layer
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageFilter selects messages by sender, time range and content. Zero fields are ignored, so an empty
+// filter matches every message.
+type MessageFilter struct {
+	// SenderIDs matches messages sent by any of these users
+	SenderIDs []string
+	// SenderNames matches system messages sent under any of these names
+	SenderNames []string
+	SentAfter   time.Time
+	SentBefore  time.Time
+	// Contains matches messages with a part body containing the string
+	Contains string
+	// Match is an optional predicate applied after all other conditions
+	Match func(MessageResponse) bool
+}
+
+// DeleteOptions configures DeleteMessages
+type DeleteOptions struct {
+	// Concurrency is the number of simultaneous deletions, defaults to DefaultConcurrency
+	Concurrency int
+	// DryRun only reports the messages that would be deleted
+	DryRun bool
+}
+
+// DeletionReport is the outcome of DeleteMessages. Matched lists every message ID selected by the filter,
+// Deleted those successfully deleted and Failed the error for each message that could not be deleted.
+type DeletionReport struct {
+	ConversationID string
+	DryRun         bool
+	Scanned        int
+	Matched        []string
+	Deleted        []string
+	Failed         map[string]error
+}
+
+// Matches reports whether a message satisfies every condition of the filter
+func (f MessageFilter) Matches(m MessageResponse) bool {
+	if (len(f.SenderIDs) > 0 || len(f.SenderNames) > 0) &&
+		!(m.Sender.UserID != "" && containsString(f.SenderIDs, m.Sender.UserID)) &&
+		!(m.Sender.Name != "" && containsString(f.SenderNames, m.Sender.Name)) {
+		return false
+	}
+
+	if !inRange(m.SentAt, f.SentAfter, f.SentBefore) {
+		return false
+	}
+
+	if f.Contains != "" {
+		found := false
+		for _, p := range m.Parts {
+			if strings.Contains(p.Body, f.Contains) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Match != nil && !f.Match(m) {
+		return false
+	}
+	return true
+}
+
+// DeleteMessages walks a conversation's history and deletes, for all recipients, every message matching the
+// filter. The returned error is only set when the history could not be read, failed deletions are listed in
+// the report.
+func (l *Layer) DeleteMessages(convID string, f MessageFilter, opts DeleteOptions) (DeletionReport, error) {
+	r := DeletionReport{ConversationID: convID, DryRun: opts.DryRun, Matched: []string{}, Deleted: []string{}, Failed: map[string]error{}}
+
+	it := l.IterateMessages(convID, HistoryOptions{})
+	for it.Next() {
+		m := it.Message()
+		// history is walked newest first, so nothing older can match
+		if !f.SentAfter.IsZero() && !m.SentAt.After(f.SentAfter) {
+			break
+		}
+
+		r.Scanned++
+		if f.Matches(m) {
+			r.Matched = append(r.Matched, m.GetID())
+		}
+	}
+	if err := it.Err(); err != nil {
+		return r, err
+	}
+
+	if opts.DryRun {
+		return r, nil
+	}
+
+	mu := sync.Mutex{}
+	parallel(len(r.Matched), opts.Concurrency, func(i int) {
+		msgID := r.Matched[i]
+		if _, err := l.DeleteMessage(convID, msgID); err != nil {
+			mu.Lock()
+			r.Failed[msgID] = err
+			mu.Unlock()
+		}
+	})
+
+	for _, msgID := range r.Matched {
+		if _, ok := r.Failed[msgID]; !ok {
+			r.Deleted = append(r.Deleted, msgID)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,61 @@
+package layer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageFilterMatches(t *testing.T) {
+	now := time.Now()
+	m := MessageResponse{
+		Sender: Sender{UserID: "user1"},
+		SentAt: now.Add(-10 * time.Minute),
+		Parts:  []Parts{TextPart("hello"), TextPart("buy cheap stuff")},
+	}
+	bot := MessageResponse{Sender: Sender{Name: "Order Bot"}, SentAt: now}
+
+	require.True(t, MessageFilter{}.Matches(m))
+	require.True(t, MessageFilter{SenderIDs: []string{"user2", "user1"}}.Matches(m))
+	require.False(t, MessageFilter{SenderIDs: []string{"user2"}}.Matches(m))
+	require.False(t, MessageFilter{SenderIDs: []string{"user1"}}.Matches(bot))
+	require.True(t, MessageFilter{SenderIDs: []string{"user1"}, SenderNames: []string{"Order Bot"}}.Matches(bot))
+	require.True(t, MessageFilter{SentAfter: now.Add(-time.Hour)}.Matches(m))
+	require.False(t, MessageFilter{SentBefore: now.Add(-time.Hour)}.Matches(m))
+	require.True(t, MessageFilter{Contains: "cheap"}.Matches(m))
+	require.False(t, MessageFilter{Contains: "expensive"}.Matches(m))
+	require.False(t, MessageFilter{Match: func(MessageResponse) bool { return false }}.Matches(m))
+}
+
+func TestDeleteMessages(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	for _, sender := range []string{user1, user2, user1} {
+		_, err := l.SendMessage(convID, sender, []Parts{TextPart("Hello")}, Notification{})
+		require.NoError(t, err)
+	}
+
+	f := MessageFilter{SenderIDs: []string{user1}, SentAfter: time.Now().Add(-time.Hour)}
+	r, err := l.DeleteMessages(convID, f, DeleteOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, r.Scanned)
+	require.Len(t, r.Matched, 2)
+	require.Len(t, r.Deleted, 0)
+
+	r, err = l.DeleteMessages(convID, f, DeleteOptions{Concurrency: 2})
+	require.NoError(t, err)
+	require.Len(t, r.Deleted, 2)
+	require.Len(t, r.Failed, 0)
+
+	msgs, err := l.GetAllMessages(convID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, user2, msgs[0].Sender.UserID)
+}
@@ -2,8 +2,15 @@ package layer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNotParticipant is returned when a notification override targets a user who will not receive the message
+	ErrNotParticipant = errors.New("Not A Participant")
 )
 
 // Notification is the payload of a push notification in layer
@@ -11,9 +18,65 @@ type Notification struct {
 	Title      string                  `json:"title,omitempty"`
 	Text       string                  `json:"text,omitempty"`
 	Sound      string                  `json:"sound,omitempty"`
+	APNS       *APNSOptions            `json:"apns,omitempty"`
+	Android    *AndroidOptions         `json:"gcm,omitempty"`
 	Recipients map[string]Notification `json:"recipients,omitempty"`
 }
 
+// NotificationBuilder produces a Notification with a default payload and per-recipient overrides
+type NotificationBuilder struct {
+	def       Notification
+	overrides map[string]Notification
+}
+
+// NewNotificationBuilder returns a builder sending def to every recipient without an override
+func NewNotificationBuilder(def Notification) *NotificationBuilder {
+	def.Recipients = nil
+	return &NotificationBuilder{def: def, overrides: map[string]Notification{}}
+}
+
+// Override sends n instead of the default notification to the given users, e.g. those mentioned in a message
+func (b *NotificationBuilder) Override(n Notification, userIDs ...string) *NotificationBuilder {
+	n.Recipients = nil
+	for _, u := range userIDs {
+		b.overrides[u] = n
+	}
+	return b
+}
+
+// Suppress sends no alert to the given users. They are given an empty notification, which carries no text or
+// sound, so the message is delivered silently.
+func (b *NotificationBuilder) Suppress(userIDs ...string) *NotificationBuilder {
+	return b.Override(Notification{}, userIDs...)
+}
+
+// Build returns the notification to pass to SendMessage or SendAnnouncement. Every overridden user must be in
+// participants, the conversation participants or announcement recipients. A nil participants list skips this
+// check, as needed for announcements sent to everyone.
+func (b *NotificationBuilder) Build(participants []string) (Notification, error) {
+	if participants != nil {
+		missing := []string{}
+		for u := range b.overrides {
+			if !containsString(participants, u) {
+				missing = append(missing, u)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return Notification{}, fmt.Errorf("%w: %s", ErrNotParticipant, strings.Join(missing, ", "))
+		}
+	}
+
+	n := b.def
+	if len(b.overrides) > 0 {
+		n.Recipients = map[string]Notification{}
+		for u, o := range b.overrides {
+			n.Recipients[u] = o
+		}
+	}
+	return n, nil
+}
+
 // SetBadgeRequest holds the number to set the badge count to
 type SetBadgeRequest struct {
 	Count int `json:"external_unread_count"`
@@ -40,8 +103,8 @@ func (l *Layer) SetUsersBadge(userID string, count int) (ok bool, err error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent {
-		return false, fmt.Errorf("Responded with Error Code %d", resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		return false, err
 	}
 
 	return true, nil
@@ -56,6 +119,10 @@ func (l *Layer) GetUsersBadge(userID string) (GetBadgeResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return GetBadgeResponse{}, err
+	}
+
 	m := GetBadgeResponse{}
 	json.NewDecoder(resp.Body).Decode(&m)
 	return m, err
@@ -1,6 +1,7 @@
 package layer
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/pborman/uuid"
@@ -27,3 +28,48 @@ func TestGetUsersBadge(t *testing.T) {
 
 	require.Equal(t, res.UnreadExternal, 12)
 }
+
+func TestNotificationBuilder(t *testing.T) {
+	def := Notification{Text: "New message", Sound: "chime.aiff"}
+	mention := Notification{Title: "You were mentioned", Text: "@user2 look at this", Sound: "ding.aiff"}
+
+	n, err := NewNotificationBuilder(def).
+		Override(mention, "user2", "user3").
+		Suppress("user4").
+		Build([]string{"user1", "user2", "user3", "user4"})
+	require.NoError(t, err)
+	require.Equal(t, def.Text, n.Text)
+	require.Len(t, n.Recipients, 3)
+	require.Equal(t, mention, n.Recipients["user2"])
+	require.Equal(t, mention, n.Recipients["user3"])
+	require.Equal(t, Notification{}, n.Recipients["user4"])
+
+	n, err = NewNotificationBuilder(def).Build([]string{"user1"})
+	require.NoError(t, err)
+	require.Equal(t, def, n)
+
+	_, err = NewNotificationBuilder(def).Override(mention, "user9", "user8").Build([]string{"user1"})
+	require.True(t, errors.Is(err, ErrNotParticipant))
+	require.Contains(t, err.Error(), "user8, user9")
+
+	_, err = NewNotificationBuilder(def).Suppress("user9").Build(nil)
+	require.NoError(t, err)
+}
+
+func TestSendMessageWithNotificationBuilder(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	user3 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2, user3}, true, Conversation{})
+	require.NoError(t, err)
+	defer cleanUpConversation(res.GetID())
+
+	n, err := NewNotificationBuilder(Notification{Text: "New message"}).
+		Override(Notification{Text: "You were mentioned"}, user2).
+		Suppress(user3).
+		Build(res.Participants)
+	require.NoError(t, err)
+
+	_, err = l.SendMessage(res.GetID(), user1, []Parts{TextPart("Hello @user2")}, n)
+	require.NoError(t, err)
+}
@@ -0,0 +1,486 @@
+package layer
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// OutboxMessage is an outbox entry delivered with SendMessage
+	OutboxMessage = "message"
+	// OutboxAnnouncement is an outbox entry delivered with SendAnnouncement
+	OutboxAnnouncement = "announcement"
+)
+
+var (
+	// ErrOutboxClosed is returned when enqueueing into an outbox that has been closed
+	ErrOutboxClosed = errors.New("Outbox Closed")
+)
+
+// OutboxState is the delivery state reported for an outbox entry
+type OutboxState string
+
+const (
+	// OutboxDelivered means Layer accepted the entry, or had already accepted it before a restart
+	OutboxDelivered OutboxState = "delivered"
+	// OutboxRetrying means a delivery attempt failed temporarily and will be retried
+	OutboxRetrying OutboxState = "retrying"
+	// OutboxFailed means delivery failed permanently or ran out of attempts, the entry is dropped
+	OutboxFailed OutboxState = "failed"
+)
+
+// OutboxEntry is a message or announcement waiting in the outbox. ID doubles as the dedupe ID sent to Layer,
+// so an entry replayed after a crash is never delivered twice.
+type OutboxEntry struct {
+	ID             string               `json:"id"`
+	Kind           string               `json:"kind"`
+	ConversationID string               `json:"conversation_id,omitempty"`
+	Sender         Sender               `json:"sender,omitempty"`
+	Parts          []Parts              `json:"parts,omitempty"`
+	Notification   Notification         `json:"notification,omitempty"`
+	Announcement   *AnnouncementRequest `json:"announcement,omitempty"`
+	Enqueued       time.Time            `json:"enqueued_at"`
+}
+
+// OutboxStatus is passed to the OnStatus callback after every delivery attempt
+type OutboxStatus struct {
+	Entry   OutboxEntry
+	State   OutboxState
+	Attempt int
+	// ResultID is the ID of the created message or announcement once delivered
+	ResultID string
+	Err      error
+}
+
+// OutboxOptions configures an Outbox
+type OutboxOptions struct {
+	// MaxAttempts is the number of delivery attempts before an entry fails, defaults to 5
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each further retry, defaults to one second
+	Backoff time.Duration
+	// OnStatus is called from the delivery goroutine after every attempt
+	OnStatus func(OutboxStatus)
+	// CompactAfter is the number of entries finished after which the log is rewritten with only the pending
+	// entries, defaults to 1000
+	CompactAfter int
+}
+
+// Outbox delivers messages and announcements in the background from a local write-ahead log. Entries are
+// written to the log before Enqueue returns and removed from it once delivered or failed, so pending entries
+// survive a crash and are delivered when the outbox is reopened. Entries are delivered one at a time in the
+// order they were enqueued.
+type Outbox struct {
+	opts OutboxOptions
+	send func(OutboxEntry) (string, error)
+
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	pending []OutboxEntry
+	closed  bool
+	// finished counts the entries finished since the log was last compacted
+	finished int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+type outboxRecord struct {
+	Op       string       `json:"op"`
+	Entry    *OutboxEntry `json:"entry,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	ResultID string       `json:"result_id,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// OpenOutbox opens, or creates, the outbox log at path and starts delivering any entries still pending in it
+func (l *Layer) OpenOutbox(path string, opts OutboxOptions) (*Outbox, error) {
+	return openOutbox(path, opts, l.deliver)
+}
+
+func openOutbox(path string, opts OutboxOptions, send func(OutboxEntry) (string, error)) (*Outbox, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.CompactAfter <= 0 {
+		opts.CompactAfter = 1000
+	}
+
+	pending, err := replayOutbox(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := compactOutbox(path, pending)
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	o := &Outbox{
+		opts:    opts,
+		send:    send,
+		path:    path,
+		f:       f,
+		pending: pending,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go o.run()
+	return o, nil
+}
+
+// EnqueueMessage durably queues a message and returns the ID of its outbox entry
+func (o *Outbox) EnqueueMessage(convID string, sender Sender, parts []Parts, n Notification) (string, error) {
+	return o.EnqueueMessageWithKey("", convID, sender, parts, n)
+}
+
+// EnqueueMessageWithKey is EnqueueMessage made idempotent on key, a caller chosen ID of the message such as the
+// ID of the row it originates from. Enqueueing the same key again, even after a crash in the middle of the first
+// call, returns the same entry ID and the message is sent at most once. An empty key behaves as EnqueueMessage.
+func (o *Outbox) EnqueueMessageWithKey(key, convID string, sender Sender, parts []Parts, n Notification) (string, error) {
+	if (sender.UserID == "") == (sender.Name == "") {
+		return "", ErrInvalidSender
+	}
+	if err := n.Validate(); err != nil {
+		return "", err
+	}
+	return o.enqueue(key, OutboxEntry{Kind: OutboxMessage, ConversationID: convID, Sender: sender, Parts: parts, Notification: n})
+}
+
+// EnqueueAnnouncement durably queues an announcement and returns the ID of its outbox entry
+func (o *Outbox) EnqueueAnnouncement(req AnnouncementRequest) (string, error) {
+	return o.EnqueueAnnouncementWithKey("", req)
+}
+
+// EnqueueAnnouncementWithKey is EnqueueAnnouncement made idempotent on key, see EnqueueMessageWithKey
+func (o *Outbox) EnqueueAnnouncementWithKey(key string, req AnnouncementRequest) (string, error) {
+	if err := validateRecipients(req.Recipients); err != nil {
+		return "", err
+	}
+	if err := req.Notification.Validate(); err != nil {
+		return "", err
+	}
+	return o.enqueue(key, OutboxEntry{Kind: OutboxAnnouncement, Announcement: &req})
+}
+
+// Pending returns the entries not yet delivered, in delivery order
+func (o *Outbox) Pending() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]OutboxEntry{}, o.pending...)
+}
+
+// Close stops delivery and closes the log. Pending entries are delivered when the outbox is next opened.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	o.mu.Unlock()
+
+	close(o.stop)
+	<-o.done
+	return o.f.Close()
+}
+
+// enqueue appends an entry to the log. The ID of an entry with a key is derived from it, so that an entry still
+// pending is not queued twice and one already delivered is discarded by Layer as a duplicate.
+func (o *Outbox) enqueue(key string, e OutboxEntry) (string, error) {
+	id := nameUUID("outbox/" + key)
+	if key == "" {
+		var err error
+		if id, err = newUUID(); err != nil {
+			return "", err
+		}
+	}
+	e.ID, e.Enqueued = id, time.Now().UTC()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return "", ErrOutboxClosed
+	}
+	for _, p := range o.pending {
+		if p.ID == e.ID {
+			return p.ID, nil
+		}
+	}
+	if err := o.append(outboxRecord{Op: "enqueue", Entry: &e}); err != nil {
+		return "", err
+	}
+	o.pending = append(o.pending, e)
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return e.ID, nil
+}
+
+// append writes a record to the log and syncs it to disk, o.mu must be held
+func (o *Outbox) append(r outboxRecord) error {
+	b, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	if _, err := o.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return o.f.Sync()
+}
+
+func (o *Outbox) run() {
+	defer close(o.done)
+	for {
+		o.mu.Lock()
+		if len(o.pending) == 0 {
+			o.mu.Unlock()
+			select {
+			case <-o.wake:
+				continue
+			case <-o.stop:
+				return
+			}
+		}
+		e := o.pending[0]
+		o.mu.Unlock()
+
+		if !o.deliver(e) {
+			return
+		}
+	}
+}
+
+// deliver attempts an entry until it is delivered or fails, returning false if the outbox was closed meanwhile
+func (o *Outbox) deliver(e OutboxEntry) bool {
+	backoff := o.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		resultID, err := o.send(e)
+		if ae, ok := err.(*APIError); ok && ae.StatusCode == http.StatusConflict {
+			// Layer already holds an object created with this dedupe ID
+			resultID, err = conflictID(ae), nil
+		}
+
+		s := OutboxStatus{Entry: e, Attempt: attempt, ResultID: resultID, Err: err}
+		switch {
+		case err == nil:
+			s.State = OutboxDelivered
+		case attempt < o.opts.MaxAttempts && temporary(err):
+			s.State = OutboxRetrying
+		default:
+			s.State = OutboxFailed
+		}
+
+		if s.State != OutboxRetrying {
+			o.finish(s)
+			return true
+		}
+		if o.opts.OnStatus != nil {
+			o.opts.OnStatus(s)
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-o.stop:
+			return false
+		}
+	}
+}
+
+// finish records the final state of the head entry and reports it
+func (o *Outbox) finish(s OutboxStatus) {
+	r := outboxRecord{Op: string(s.State), ID: s.Entry.ID, ResultID: s.ResultID}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+	}
+
+	o.mu.Lock()
+	if err := o.append(r); err != nil && s.Err == nil {
+		// the entry stays pending in the log and is resent, without duplicate, after a restart
+		s.Err = err
+	}
+	o.pending = o.pending[1:]
+	if o.finished++; o.finished >= o.opts.CompactAfter {
+		o.compact()
+	}
+	o.mu.Unlock()
+
+	if o.opts.OnStatus != nil {
+		o.opts.OnStatus(s)
+	}
+}
+
+// compact rewrites the log with only the pending entries so that it does not grow without bound, o.mu must be
+// held. If the log cannot be rewritten the current one is kept and compacting is tried again on the next finish.
+func (o *Outbox) compact() {
+	f, _ := compactOutbox(o.path, o.pending)
+	if f == nil {
+		return
+	}
+	o.f.Close()
+	o.f, o.finished = f, 0
+}
+
+// deliver sends an outbox entry to Layer using its ID as the dedupe ID
+func (l *Layer) deliver(e OutboxEntry) (string, error) {
+	dedupe := e.ID
+	switch e.Kind {
+	case OutboxMessage:
+		m, err := l.sendMessage(e.ConversationID, e.Sender, e.Parts, e.Notification, &dedupe)
+		return m.ID, err
+	case OutboxAnnouncement:
+		if e.Announcement == nil {
+			return "", fmt.Errorf("Outbox entry %s has no announcement", e.ID)
+		}
+		a, err := l.sendAnnouncement(*e.Announcement, &dedupe)
+		return a.ID, err
+	}
+	return "", fmt.Errorf("Outbox entry %s has unknown kind %q", e.ID, e.Kind)
+}
+
+// replayOutbox reads the log at path and returns the entries that were enqueued but never finished
+func replayOutbox(path string) ([]OutboxEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []OutboxEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []OutboxEntry{}
+	finished := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		r := outboxRecord{}
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			// a torn write from a crash can only affect the last record
+			continue
+		}
+		if r.Op == "enqueue" && r.Entry != nil {
+			entries = append(entries, *r.Entry)
+		} else {
+			finished[r.ID] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := []OutboxEntry{}
+	for _, e := range entries {
+		if !finished[e.ID] {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+// compactOutbox atomically rewrites the log with only the pending entries and returns it open for appending.
+// The file is returned along with the error when only syncing the directory failed, since the log has then
+// been replaced already.
+func compactOutbox(path string, pending []OutboxEntry) (*os.File, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range pending {
+		if err := enc.Encode(&outboxRecord{Op: "enqueue", Entry: &pending[i]}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := syncDir(path); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// syncDir flushes the directory holding path, making a rename into it durable
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// temporary reports whether a failed request may succeed if retried
+func temporary(err error) bool {
+	if e, ok := err.(*APIError); ok {
+		return e.Temporary()
+	}
+	return err != ErrInvalidSender && !errors.Is(err, ErrPayloadTooLarge)
+}
+
+// conflictID extracts the ID of the existing object from a dedupe conflict
+func conflictID(e *APIError) string {
+	if d, ok := e.Data.(map[string]interface{}); ok {
+		if id, ok := d["id"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// nameUUID returns a version 5 style UUID derived from name, the same for the same name
+func nameUUID(name string) string {
+	b := sha1.Sum([]byte(name))
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
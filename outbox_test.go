@@ -0,0 +1,261 @@
+package layer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type statusRecorder struct {
+	mu       sync.Mutex
+	statuses []OutboxStatus
+	final    chan OutboxStatus
+}
+
+func newStatusRecorder() *statusRecorder {
+	return &statusRecorder{final: make(chan OutboxStatus, 10)}
+}
+
+func (r *statusRecorder) record(s OutboxStatus) {
+	r.mu.Lock()
+	r.statuses = append(r.statuses, s)
+	r.mu.Unlock()
+	if s.State != OutboxRetrying {
+		r.final <- s
+	}
+}
+
+func (r *statusRecorder) wait(t *testing.T) OutboxStatus {
+	select {
+	case s := <-r.final:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for outbox delivery")
+	}
+	return OutboxStatus{}
+}
+
+func TestOutboxDelivers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	rec := newStatusRecorder()
+	calls := 0
+	send := func(e OutboxEntry) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", &APIError{StatusCode: 503}
+		}
+		return "layer:///messages/" + e.ID, nil
+	}
+
+	o, err := openOutbox(path, OutboxOptions{Backoff: time.Millisecond, OnStatus: rec.record}, send)
+	require.NoError(t, err)
+	defer o.Close()
+
+	id, err := o.EnqueueMessage("conv", Sender{UserID: "user1"}, []Parts{TextPart("Hello")}, Notification{})
+	require.NoError(t, err)
+
+	s := rec.wait(t)
+	require.Equal(t, OutboxDelivered, s.State)
+	require.Equal(t, id, s.Entry.ID)
+	require.Equal(t, 2, s.Attempt)
+	require.Equal(t, "layer:///messages/"+id, s.ResultID)
+	require.Equal(t, OutboxRetrying, rec.statuses[0].State)
+	require.Len(t, o.Pending(), 0)
+
+	_, err = o.EnqueueMessage("conv", Sender{}, []Parts{TextPart("Hello")}, Notification{})
+	require.Equal(t, ErrInvalidSender, err)
+}
+
+func TestOutboxPermanentFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	rec := newStatusRecorder()
+	send := func(e OutboxEntry) (string, error) {
+		return "", &APIError{StatusCode: 422, Message: "Invalid"}
+	}
+
+	o, err := openOutbox(path, OutboxOptions{Backoff: time.Millisecond, OnStatus: rec.record}, send)
+	require.NoError(t, err)
+	defer o.Close()
+
+	_, err = o.EnqueueAnnouncement(AnnouncementRequest{Recipients: []string{"everyone"}})
+	require.NoError(t, err)
+
+	s := rec.wait(t)
+	require.Equal(t, OutboxFailed, s.State)
+	require.Equal(t, 1, s.Attempt)
+	require.Error(t, s.Err)
+}
+
+func TestOutboxDedupeConflict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	rec := newStatusRecorder()
+	send := func(e OutboxEntry) (string, error) {
+		return "", &APIError{StatusCode: 409, Data: map[string]interface{}{"id": "layer:///messages/1"}}
+	}
+
+	o, err := openOutbox(path, OutboxOptions{OnStatus: rec.record}, send)
+	require.NoError(t, err)
+	defer o.Close()
+
+	_, err = o.EnqueueMessage("conv", Sender{UserID: "user1"}, []Parts{TextPart("Hello")}, Notification{})
+	require.NoError(t, err)
+
+	s := rec.wait(t)
+	require.Equal(t, OutboxDelivered, s.State)
+	require.Equal(t, "layer:///messages/1", s.ResultID)
+}
+
+func TestOutboxReplaysAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	blocked := func(e OutboxEntry) (string, error) {
+		return "", errors.New("network down")
+	}
+
+	o, err := openOutbox(path, OutboxOptions{Backoff: time.Hour}, blocked)
+	require.NoError(t, err)
+	id1, err := o.EnqueueMessage("conv", Sender{UserID: "user1"}, []Parts{TextPart("first")}, Notification{})
+	require.NoError(t, err)
+	id2, err := o.EnqueueMessage("conv", Sender{Name: "Order Bot"}, []Parts{TextPart("second")}, Notification{})
+	require.NoError(t, err)
+	require.NoError(t, o.Close())
+
+	// simulate a crash in the middle of writing a record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	f.WriteString(`{"op":"deliv`)
+	f.Close()
+
+	rec := newStatusRecorder()
+	sent := []string{}
+	send := func(e OutboxEntry) (string, error) {
+		sent = append(sent, e.ID)
+		return e.ID, nil
+	}
+	o, err = openOutbox(path, OutboxOptions{OnStatus: rec.record}, send)
+	require.NoError(t, err)
+
+	rec.wait(t)
+	rec.wait(t)
+	require.Equal(t, []string{id1, id2}, sent)
+	require.NoError(t, o.Close())
+
+	pending, err := replayOutbox(path)
+	require.NoError(t, err)
+	require.Len(t, pending, 0)
+
+	_, err = o.EnqueueMessage("conv", Sender{UserID: "user1"}, []Parts{TextPart("late")}, Notification{})
+	require.Equal(t, ErrOutboxClosed, err)
+}
+
+func TestOutboxEnqueueWithKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	blocked := func(e OutboxEntry) (string, error) {
+		return "", errors.New("network down")
+	}
+
+	o, err := openOutbox(path, OutboxOptions{Backoff: time.Hour}, blocked)
+	require.NoError(t, err)
+	id1, err := o.EnqueueMessageWithKey("order-1", "conv", Sender{UserID: "user1"}, []Parts{TextPart("first")}, Notification{})
+	require.NoError(t, err)
+	again, err := o.EnqueueMessageWithKey("order-1", "conv", Sender{UserID: "user1"}, []Parts{TextPart("first")}, Notification{})
+	require.NoError(t, err)
+	require.Equal(t, id1, again)
+	id2, err := o.EnqueueAnnouncementWithKey("order-2", AnnouncementRequest{Recipients: []string{"user1"}})
+	require.NoError(t, err)
+	require.NotEqual(t, id1, id2)
+	require.Len(t, o.Pending(), 2)
+	require.NoError(t, o.Close())
+
+	// the caller retries after a crash that happened before the first call returned
+	rec := newStatusRecorder()
+	var mu sync.Mutex
+	sent := []string{}
+	send := func(e OutboxEntry) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if containsString(sent, e.ID) {
+			return "", &APIError{StatusCode: 409, Data: map[string]interface{}{"id": "existing"}}
+		}
+		sent = append(sent, e.ID)
+		return e.ID, nil
+	}
+	o, err = openOutbox(path, OutboxOptions{OnStatus: rec.record}, send)
+	require.NoError(t, err)
+	defer o.Close()
+	again, err = o.EnqueueMessageWithKey("order-1", "conv", Sender{UserID: "user1"}, []Parts{TextPart("first")}, Notification{})
+	require.NoError(t, err)
+	require.Equal(t, id1, again)
+
+	rec.wait(t)
+	rec.wait(t)
+
+	// once delivered, the same key is sent with the same dedupe ID and Layer discards it
+	again, err = o.EnqueueMessageWithKey("order-1", "conv", Sender{UserID: "user1"}, []Parts{TextPart("first")}, Notification{})
+	require.NoError(t, err)
+	require.Equal(t, id1, again)
+	s := rec.wait(t)
+	require.Equal(t, OutboxDelivered, s.State)
+	require.Equal(t, "existing", s.ResultID)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{id1, id2}, sent)
+}
+
+func TestOutboxCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	rec := newStatusRecorder()
+	send := func(e OutboxEntry) (string, error) {
+		return e.ID, nil
+	}
+	o, err := openOutbox(path, OutboxOptions{OnStatus: rec.record, CompactAfter: 2}, send)
+	require.NoError(t, err)
+	defer o.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := o.EnqueueMessage("conv", Sender{UserID: "user1"}, []Parts{TextPart("Hello")}, Notification{})
+		require.NoError(t, err)
+		rec.wait(t)
+	}
+
+	// the log was emptied after the fourth entry and only holds the records of the fifth
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(b, []byte("\n")))
+
+	// the sixth entry compacts the log again
+	_, err = o.EnqueueMessage("conv", Sender{UserID: "user1"}, []Parts{TextPart("Hello")}, Notification{})
+	require.NoError(t, err)
+	rec.wait(t)
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 0, bytes.Count(b, []byte("\n")))
+}
+
+func TestOutboxSendMessage(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	rec := newStatusRecorder()
+	o, err := l.OpenOutbox(filepath.Join(t.TempDir(), "outbox.log"), OutboxOptions{OnStatus: rec.record})
+	require.NoError(t, err)
+	defer o.Close()
+
+	_, err = o.EnqueueMessage(convID, Sender{UserID: user1}, []Parts{TextPart("Hello")}, Notification{})
+	require.NoError(t, err)
+
+	s := rec.wait(t)
+	require.Equal(t, OutboxDelivered, s.State)
+	require.NotEmpty(t, s.ResultID)
+}
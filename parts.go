@@ -0,0 +1,123 @@
+package layer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Base64Encoding is the only encoding Layer accepts for part bodies
+	Base64Encoding = "base64"
+	// LocationMimeType is the MIME type used by Layer clients for location parts
+	LocationMimeType = "location/coordinate"
+)
+
+var (
+	// ErrInvalidMimeType is returned when a part's MIME type cannot be parsed
+	ErrInvalidMimeType = errors.New("Invalid MIME Type")
+	// ErrInvalidEncoding is returned when a part has an encoding other than base64, or a body that does not decode
+	ErrInvalidEncoding = errors.New("Invalid Encoding")
+	// ErrPartTooLarge is returned when a part body is too large to be sent inline. Such parts can still be sent
+	// with SendMessageWithRichContent.
+	ErrPartTooLarge = errors.New("Part Too Large")
+	// ErrEmptyParts is returned when a message has no parts
+	ErrEmptyParts = errors.New("Empty Parts")
+	// ErrBodyAndContent is returned when a part has both an inline body and rich content
+	ErrBodyAndContent = errors.New("Part Has Both Body And Content")
+)
+
+// Location is the body of a location part
+type Location struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+}
+
+// TextPart returns a text/plain part
+func TextPart(body string) Parts {
+	return Parts{MimeType: "text/plain", Body: body}
+}
+
+// MarkdownPart returns a text/markdown part
+func MarkdownPart(body string) Parts {
+	return Parts{MimeType: "text/markdown", Body: body}
+}
+
+// JSONPart returns an application/json part holding v encoded as JSON
+func JSONPart(v interface{}) (Parts, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Parts{}, err
+	}
+	return Parts{MimeType: "application/json", Body: string(b)}, nil
+}
+
+// ImagePart returns a base64 encoded part for an image of the given image/* MIME type
+func ImagePart(mimeType string, data []byte) (Parts, error) {
+	if !strings.HasPrefix(mimeType, "image/") {
+		return Parts{}, fmt.Errorf("%w: %s is not an image", ErrInvalidMimeType, mimeType)
+	}
+	return Parts{MimeType: mimeType, Body: base64.StdEncoding.EncodeToString(data), Encoding: Base64Encoding}, nil
+}
+
+// FilePart returns a part for arbitrary data. Text data is sent as is, anything else is base64 encoded.
+func FilePart(mimeType string, data []byte) Parts {
+	if strings.HasPrefix(mimeType, "text/") && utf8.Valid(data) {
+		return Parts{MimeType: mimeType, Body: string(data)}
+	}
+	return Parts{MimeType: mimeType, Body: base64.StdEncoding.EncodeToString(data), Encoding: Base64Encoding}
+}
+
+// LocationPart returns a location/coordinate part for the given coordinates
+func LocationPart(lat, lon float64) Parts {
+	b, _ := json.Marshal(Location{Latitude: lat, Longitude: lon})
+	return Parts{MimeType: LocationMimeType, Body: string(b)}
+}
+
+// ValidatePart checks that a part has a well formed MIME type, a valid encoding and a body small enough to be
+// sent inline by SendMessage
+func ValidatePart(p Parts) error {
+	if _, _, err := mime.ParseMediaType(p.MimeType); err != nil || !strings.Contains(p.MimeType, "/") {
+		return ErrInvalidMimeType
+	}
+
+	switch p.Encoding {
+	case "":
+	case Base64Encoding:
+		if _, err := base64.StdEncoding.DecodeString(p.Body); err != nil {
+			return ErrInvalidEncoding
+		}
+	default:
+		return ErrInvalidEncoding
+	}
+
+	if p.Content != nil {
+		if p.Body != "" {
+			return ErrBodyAndContent
+		}
+		return nil
+	}
+
+	if len(p.Body) > MaxInlineBodySize {
+		return ErrPartTooLarge
+	}
+	return nil
+}
+
+// ValidateParts runs ValidatePart on every part, returning the first error along with the index of its part
+func ValidateParts(parts []Parts) error {
+	if len(parts) == 0 {
+		return ErrEmptyParts
+	}
+
+	for i, p := range parts {
+		if err := ValidatePart(p); err != nil {
+			return fmt.Errorf("Part %d: %w", i, err)
+		}
+	}
+	return nil
+}
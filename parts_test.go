@@ -0,0 +1,61 @@
+package layer
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartBuilders(t *testing.T) {
+	require.Equal(t, Parts{MimeType: "text/plain", Body: "Hello World"}, TextPart("Hello World"))
+	require.Equal(t, "text/markdown", MarkdownPart("*Hello*").MimeType)
+
+	p, err := JSONPart(map[string]int{"count": 1})
+	require.NoError(t, err)
+	require.Equal(t, Parts{MimeType: "application/json", Body: `{"count":1}`}, p)
+
+	_, err = JSONPart(make(chan int))
+	require.Error(t, err)
+
+	img := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	p, err = ImagePart("image/png", img)
+	require.NoError(t, err)
+	require.Equal(t, Base64Encoding, p.Encoding)
+	b, err := base64.StdEncoding.DecodeString(p.Body)
+	require.NoError(t, err)
+	require.Equal(t, img, b)
+
+	_, err = ImagePart("application/pdf", img)
+	require.True(t, errors.Is(err, ErrInvalidMimeType))
+
+	require.Equal(t, Parts{MimeType: "text/csv", Body: "a,b"}, FilePart("text/csv", []byte("a,b")))
+	require.Equal(t, Base64Encoding, FilePart("text/csv", []byte{0xff, 0xfe}).Encoding)
+	require.Equal(t, Base64Encoding, FilePart("application/pdf", []byte("%PDF")).Encoding)
+
+	require.Equal(t, Parts{MimeType: LocationMimeType, Body: `{"lat":37.7749,"lon":-122.4194}`}, LocationPart(37.7749, -122.4194))
+}
+
+func TestValidatePart(t *testing.T) {
+	require.NoError(t, ValidatePart(TextPart("Hello World")))
+	require.NoError(t, ValidatePart(Parts{MimeType: "text/plain; charset=utf-8", Body: "Hello"}))
+	require.NoError(t, ValidatePart(Parts{MimeType: "image/png", Content: &Content{ID: "layer:///content/1"}}))
+
+	require.Equal(t, ErrInvalidMimeType, ValidatePart(Parts{MimeType: "text", Body: "Hello"}))
+	require.Equal(t, ErrInvalidMimeType, ValidatePart(Parts{Body: "Hello"}))
+	require.Equal(t, ErrInvalidEncoding, ValidatePart(Parts{MimeType: "image/png", Body: "!!", Encoding: Base64Encoding}))
+	require.Equal(t, ErrInvalidEncoding, ValidatePart(Parts{MimeType: "image/png", Body: "abc", Encoding: "hex"}))
+	require.Equal(t, ErrPartTooLarge, ValidatePart(TextPart(strings.Repeat("a", MaxInlineBodySize+1))))
+	require.Equal(t, ErrBodyAndContent, ValidatePart(Parts{MimeType: "image/png", Body: "a", Content: &Content{ID: "layer:///content/1"}}))
+}
+
+func TestValidateParts(t *testing.T) {
+	require.NoError(t, ValidateParts([]Parts{TextPart("Hello"), LocationPart(0, 0)}))
+	require.Error(t, ValidateParts(nil))
+
+	err := ValidateParts([]Parts{TextPart("Hello"), Parts{MimeType: "bad"}})
+	require.True(t, errors.Is(err, ErrInvalidMimeType))
+	require.Contains(t, err.Error(), "Part 1")
+}
@@ -0,0 +1,322 @@
+package layer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPolicyNeedsRecipients is returned when a notification policy is applied to an announcement to everyone,
+	// whose recipients cannot be evaluated one by one
+	ErrPolicyNeedsRecipients = errors.New("Notification Policy Needs Explicit Recipients")
+)
+
+// PolicyAction is what a notification policy does to the notification of a recipient. Actions are ordered by
+// severity, the most severe action of all rules wins.
+type PolicyAction int
+
+const (
+	// PolicyDeliver leaves the notification untouched
+	PolicyDeliver PolicyAction = iota
+	// PolicyStripSound delivers the alert without a sound
+	PolicyStripSound
+	// PolicySilent delivers a background push without alert or sound, so the app can still update
+	PolicySilent
+	// PolicySuppress sends no push at all
+	PolicySuppress
+)
+
+func (a PolicyAction) String() string {
+	switch a {
+	case PolicyDeliver:
+		return "deliver"
+	case PolicyStripSound:
+		return "strip_sound"
+	case PolicySilent:
+		return "silent"
+	case PolicySuppress:
+		return "suppress"
+	}
+	return "unknown"
+}
+
+// PolicyContext is the notification about to be sent to one recipient
+type PolicyContext struct {
+	UserID string
+	// ConversationID is empty for announcements
+	ConversationID string
+	Notification   Notification
+	Now            time.Time
+}
+
+// PolicyRule decides what to do with the notification of one recipient, with a reason for reporting
+type PolicyRule interface {
+	Evaluate(ctx PolicyContext) (PolicyAction, string)
+}
+
+// PolicyRecorder is implemented by rules that keep state, such as FrequencyCap. Record is called by Sent for every
+// recipient of a notification that was sent, with the notification they received in ctx.
+type PolicyRecorder interface {
+	Record(ctx PolicyContext)
+}
+
+// PolicyDecision reports the action taken for one recipient whose notification was changed
+type PolicyDecision struct {
+	UserID string
+	Action PolicyAction
+	Reason string
+}
+
+// NotificationPolicy applies rules to the notification of every recipient before it is sent
+type NotificationPolicy struct {
+	Rules []PolicyRule
+	// Clock defaults to SystemClock
+	Clock Clock
+}
+
+// Apply returns the notification rewritten with an override for every recipient whose notification a rule
+// changed, along with the decision taken for each of them in recipients order. A notification is never made
+// less restrictive, e.g. one already suppressed is not turned into a silent push. Call Sent once the returned
+// notification has been sent.
+func (p *NotificationPolicy) Apply(n Notification, convID string, recipients []string) (Notification, []PolicyDecision) {
+	now := p.now()
+	out := n
+	decisions := []PolicyDecision{}
+	for _, u := range recipients {
+		un := recipientNotification(n, u)
+		ctx := PolicyContext{UserID: u, ConversationID: convID, Notification: un, Now: now}
+		d := PolicyDecision{UserID: u, Action: PolicyDeliver}
+		for _, r := range p.Rules {
+			if a, reason := r.Evaluate(ctx); a > d.Action {
+				d.Action, d.Reason = a, reason
+			}
+		}
+		if d.Action <= currentAction(un) {
+			continue
+		}
+
+		if len(decisions) == 0 {
+			// copy the overrides so that the caller's notification is left untouched
+			out.Recipients = map[string]Notification{}
+			for k, v := range n.Recipients {
+				out.Recipients[k] = v
+			}
+		}
+		out.Recipients[u] = applyAction(un, d.Action)
+		decisions = append(decisions, d)
+	}
+	return out, decisions
+}
+
+// Sent records a notification returned by Apply once it has been sent, so that rules such as FrequencyCap only
+// count notifications that were delivered
+func (p *NotificationPolicy) Sent(n Notification, convID string, recipients []string) {
+	now := p.now()
+	for _, u := range recipients {
+		ctx := PolicyContext{UserID: u, ConversationID: convID, Notification: recipientNotification(n, u), Now: now}
+		for _, r := range p.Rules {
+			if rec, ok := r.(PolicyRecorder); ok {
+				rec.Record(ctx)
+			}
+		}
+	}
+}
+
+func (p *NotificationPolicy) now() time.Time {
+	if p.Clock == nil {
+		return SystemClock.Now()
+	}
+	return p.Clock.Now()
+}
+
+// recipientNotification returns the notification a recipient receives, their override or the default
+func recipientNotification(n Notification, userID string) Notification {
+	if o, ok := n.Recipients[userID]; ok {
+		n = o
+	}
+	n.Recipients = nil
+	return n
+}
+
+// currentAction returns the action a notification already amounts to, e.g. PolicySuppress for the empty
+// notification of NotificationBuilder.Suppress
+func currentAction(n Notification) PolicyAction {
+	switch {
+	case n.Title != "" || n.Text != "" || n.Sound != "":
+		return PolicyDeliver
+	case n.APNS != nil && n.APNS.ContentAvailable, n.Android != nil && len(n.Android.Data) > 0:
+		return PolicySilent
+	}
+	return PolicySuppress
+}
+
+// applyAction rewrites the notification of a single recipient
+func applyAction(n Notification, a PolicyAction) Notification {
+	switch a {
+	case PolicyStripSound:
+		n.Sound = ""
+	case PolicySilent:
+		s := Notification{APNS: &APNSOptions{ContentAvailable: true}}
+		if n.APNS != nil {
+			s.APNS.ThreadID = n.APNS.ThreadID
+		}
+		if n.Android != nil {
+			s.Android = &AndroidOptions{Data: n.Android.Data}
+		}
+		n = s
+	case PolicySuppress:
+		n = Notification{}
+	}
+	return n
+}
+
+// SendMessageWithPolicy applies the policy to every participant other than the sender before sending the message
+func (l *Layer) SendMessageWithPolicy(convID, sender string, parts []Parts, n Notification, p *NotificationPolicy) (MessageResponse, []PolicyDecision, error) {
+	c, err := l.GetConversation(convID)
+	if err != nil {
+		return MessageResponse{}, nil, err
+	}
+
+	recipients := []string{}
+	for _, u := range c.Participants {
+		if u != sender {
+			recipients = append(recipients, u)
+		}
+	}
+
+	n, decisions := p.Apply(n, convID, recipients)
+	m, err := l.SendMessage(convID, sender, parts, n)
+	if err != nil {
+		return m, decisions, err
+	}
+	p.Sent(n, convID, recipients)
+	return m, decisions, nil
+}
+
+// SendAnnouncementWithPolicy applies the policy to every recipient before sending the announcement
+func (l *Layer) SendAnnouncementWithPolicy(req AnnouncementRequest, p *NotificationPolicy) (AnnouncementResponse, []PolicyDecision, error) {
+	if containsString(req.Recipients, EveryoneRecipient) {
+		return AnnouncementResponse{}, nil, ErrPolicyNeedsRecipients
+	}
+
+	var decisions []PolicyDecision
+	req.Notification, decisions = p.Apply(req.Notification, "", req.Recipients)
+	a, err := l.SendAnnouncement(req)
+	if err != nil {
+		return a, decisions, err
+	}
+	p.Sent(req.Notification, "", req.Recipients)
+	return a, decisions, nil
+}
+
+// QuietHours applies Action to recipients whose local time is between Start and End, both offsets from
+// midnight. A window with Start after End spans midnight, e.g. 22h to 7h.
+type QuietHours struct {
+	Start time.Duration
+	End   time.Duration
+	// Zones maps user IDs to their time zone, users missing from it use Default, or UTC when Default is nil
+	Zones   map[string]*time.Location
+	Default *time.Location
+	// Action defaults to PolicySilent
+	Action PolicyAction
+}
+
+// Evaluate implements PolicyRule
+func (q QuietHours) Evaluate(ctx PolicyContext) (PolicyAction, string) {
+	loc := q.Zones[ctx.UserID]
+	if loc == nil {
+		loc = q.Default
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	t := ctx.Now.In(loc)
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	quiet := tod >= q.Start && tod < q.End
+	if q.Start > q.End {
+		quiet = tod >= q.Start || tod < q.End
+	}
+	if !quiet {
+		return PolicyDeliver, ""
+	}
+
+	if q.Action == PolicyDeliver {
+		return PolicySilent, "quiet hours"
+	}
+	return q.Action, "quiet hours"
+}
+
+// ConversationMutes suppresses notifications of conversations muted by a user. It maps user IDs to the IDs of
+// the conversations they muted and has no effect on announcements.
+type ConversationMutes map[string][]string
+
+// Evaluate implements PolicyRule
+func (m ConversationMutes) Evaluate(ctx PolicyContext) (PolicyAction, string) {
+	if ctx.ConversationID != "" && containsString(m[ctx.UserID], ctx.ConversationID) {
+		return PolicySuppress, "conversation muted"
+	}
+	return PolicyDeliver, ""
+}
+
+// FrequencyCap applies Action once a user has been alerted Max times within Window. Only notifications passed to
+// NotificationPolicy.Sent with an alert count towards the cap. It is safe for concurrent use.
+type FrequencyCap struct {
+	Max    int
+	Window time.Duration
+	// Action defaults to PolicySuppress
+	Action PolicyAction
+
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+// NewFrequencyCap returns a cap of limit alerts per user within window
+func NewFrequencyCap(limit int, window time.Duration, action PolicyAction) *FrequencyCap {
+	return &FrequencyCap{Max: limit, Window: window, Action: action}
+}
+
+// Evaluate implements PolicyRule
+func (f *FrequencyCap) Evaluate(ctx PolicyContext) (PolicyAction, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.recent(ctx.UserID, ctx.Now)) < f.Max {
+		return PolicyDeliver, ""
+	}
+
+	if f.Action == PolicyDeliver {
+		return PolicySuppress, "frequency cap"
+	}
+	return f.Action, "frequency cap"
+}
+
+// Record implements PolicyRecorder
+func (f *FrequencyCap) Record(ctx PolicyContext) {
+	if ctx.Notification.Text == "" && ctx.Notification.Title == "" {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sent == nil {
+		f.sent = map[string][]time.Time{}
+	}
+	f.sent[ctx.UserID] = append(f.recent(ctx.UserID, ctx.Now), ctx.Now)
+}
+
+// recent drops the alerts of a user older than the window and returns the rest, f.mu must be held
+func (f *FrequencyCap) recent(userID string, now time.Time) []time.Time {
+	kept := []time.Time{}
+	for _, t := range f.sent[userID] {
+		if now.Sub(t) < f.Window {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		delete(f.sent, userID)
+	} else {
+		f.sent[userID] = kept
+	}
+	return kept
+}
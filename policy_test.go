@@ -0,0 +1,99 @@
+package layer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationPolicyApply(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	// 18:00 UTC is 03:00 in Tokyo
+	clock := &fakeClock{now: time.Date(2017, 6, 1, 18, 0, 0, 0, time.UTC)}
+	p := &NotificationPolicy{
+		Clock: clock,
+		Rules: []PolicyRule{
+			QuietHours{Start: 22 * time.Hour, End: 7 * time.Hour, Zones: map[string]*time.Location{"sleeper": tokyo}},
+			ConversationMutes{"muter": []string{"conv1"}},
+			QuietHours{Start: 17 * time.Hour, End: 19 * time.Hour, Zones: map[string]*time.Location{"sleeper": tokyo}, Action: PolicyStripSound},
+		},
+	}
+
+	n := Notification{
+		Text:       "hi",
+		Sound:      "chime.aiff",
+		APNS:       &APNSOptions{ThreadID: "conv1"},
+		Recipients: map[string]Notification{"vip": Notification{Text: "hey vip", Sound: "vip.aiff"}},
+	}
+	out, decisions := p.Apply(n, "conv1", []string{"sleeper", "muter", "vip", "awake"})
+
+	require.Equal(t, []PolicyDecision{
+		PolicyDecision{UserID: "sleeper", Action: PolicySilent, Reason: "quiet hours"},
+		PolicyDecision{UserID: "muter", Action: PolicySuppress, Reason: "conversation muted"},
+		PolicyDecision{UserID: "vip", Action: PolicyStripSound, Reason: "quiet hours"},
+		PolicyDecision{UserID: "awake", Action: PolicyStripSound, Reason: "quiet hours"},
+	}, decisions)
+
+	require.Equal(t, "hi", out.Text)
+	require.Equal(t, Notification{APNS: &APNSOptions{ThreadID: "conv1", ContentAvailable: true}}, out.Recipients["sleeper"])
+	require.Equal(t, Notification{}, out.Recipients["muter"])
+	require.Equal(t, Notification{Text: "hey vip"}, out.Recipients["vip"])
+	require.Equal(t, "hi", out.Recipients["awake"].Text)
+	require.Empty(t, out.Recipients["awake"].Sound)
+	require.Equal(t, "vip.aiff", n.Recipients["vip"].Sound)
+
+	out, decisions = (&NotificationPolicy{Clock: clock}).Apply(n, "conv1", []string{"awake"})
+	require.Empty(t, decisions)
+	require.Equal(t, n, out)
+}
+
+func TestFrequencyCap(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)}
+	p := &NotificationPolicy{Clock: clock, Rules: []PolicyRule{NewFrequencyCap(2, time.Hour, PolicyDeliver)}}
+	n := Notification{Text: "hi"}
+
+	for i := 0; i < 2; i++ {
+		out, decisions := p.Apply(n, "", []string{"user1", "user2"})
+		require.Empty(t, decisions)
+		// only user1's sends succeed
+		p.Sent(out, "", []string{"user1"})
+		clock.Advance(time.Minute)
+	}
+
+	// silent notifications do not count towards the cap
+	p.Sent(Notification{APNS: &APNSOptions{ContentAvailable: true}}, "", []string{"user2"})
+
+	_, decisions := p.Apply(n, "", []string{"user1", "user2"})
+	require.Equal(t, []PolicyDecision{PolicyDecision{UserID: "user1", Action: PolicySuppress, Reason: "frequency cap"}}, decisions)
+
+	clock.Advance(time.Hour)
+	_, decisions = p.Apply(n, "", []string{"user1"})
+	require.Empty(t, decisions)
+}
+
+func TestNotificationPolicyNeverWeakens(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 6, 1, 3, 0, 0, 0, time.UTC)}
+	p := &NotificationPolicy{Clock: clock, Rules: []PolicyRule{QuietHours{Start: 22 * time.Hour, End: 7 * time.Hour}}}
+
+	n, err := NewNotificationBuilder(Notification{Text: "hi", Sound: "chime.aiff"}).Suppress("muted").Build(nil)
+	require.NoError(t, err)
+	silent := Notification{APNS: &APNSOptions{ContentAvailable: true}}
+	n.Recipients["silent"] = silent
+
+	out, decisions := p.Apply(n, "", []string{"muted", "silent", "awake"})
+	require.Equal(t, []PolicyDecision{PolicyDecision{UserID: "awake", Action: PolicySilent, Reason: "quiet hours"}}, decisions)
+	require.Equal(t, Notification{}, out.Recipients["muted"])
+	require.Equal(t, silent, out.Recipients["silent"])
+
+	p.Rules = []PolicyRule{QuietHours{Start: 22 * time.Hour, End: 7 * time.Hour, Action: PolicySuppress}}
+	_, decisions = p.Apply(n, "", []string{"muted", "silent"})
+	require.Equal(t, []PolicyDecision{PolicyDecision{UserID: "silent", Action: PolicySuppress, Reason: "quiet hours"}}, decisions)
+}
+
+func TestSendAnnouncementWithPolicy(t *testing.T) {
+	_, _, err := l.SendAnnouncementWithPolicy(AnnouncementRequest{Recipients: []string{EveryoneRecipient}}, &NotificationPolicy{})
+	require.Equal(t, ErrPolicyNeedsRecipients, err)
+}
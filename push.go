@@ -0,0 +1,101 @@
+package layer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// APNSPayloadLimit is the maximum size in bytes of an iOS push payload
+	APNSPayloadLimit = 4096
+	// AndroidPayloadLimit is the maximum size in bytes of an Android push payload
+	AndroidPayloadLimit = 4096
+	// pushPayloadOverhead is reserved for the conversation and message identifiers Layer adds to every push
+	pushPayloadOverhead = 256
+)
+
+var (
+	// ErrPayloadTooLarge is returned when a notification would exceed a platform push payload limit
+	ErrPayloadTooLarge = errors.New("Push Payload Too Large")
+)
+
+// APNSOptions holds the iOS specific options of a notification
+type APNSOptions struct {
+	// Category selects the notification actions registered by the app
+	Category string `json:"category,omitempty"`
+	// ThreadID groups notifications in Notification Center
+	ThreadID string `json:"thread-id,omitempty"`
+	// MutableContent lets a notification service extension modify the notification before it is shown
+	MutableContent bool `json:"mutable-content,omitempty"`
+	// ContentAvailable wakes the app in the background
+	ContentAvailable bool `json:"content-available,omitempty"`
+	// CollapseID replaces a displayed notification with the same ID
+	CollapseID string `json:"collapse-id,omitempty"`
+}
+
+// AndroidOptions holds the Android specific options of a notification
+type AndroidOptions struct {
+	// ChannelID is the notification channel the notification is posted to
+	ChannelID string `json:"channel_id,omitempty"`
+	// CollapseKey replaces a pending notification with the same key
+	CollapseKey string `json:"collapse_key,omitempty"`
+	// Data is delivered to the app alongside the notification
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Validate checks that the notification, and the notification of every recipient override, fits in the push
+// payload limits of each platform
+func (n Notification) Validate() error {
+	if err := n.validatePayload(); err != nil {
+		return err
+	}
+
+	users := []string{}
+	for u := range n.Recipients {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	for _, u := range users {
+		if err := n.Recipients[u].validatePayload(); err != nil {
+			return fmt.Errorf("recipient %s: %w", u, err)
+		}
+	}
+	return nil
+}
+
+// validatePayload estimates the size of the payload sent to each platform, without recipient overrides
+func (n Notification) validatePayload() error {
+	alert := struct {
+		Title string `json:"title,omitempty"`
+		Text  string `json:"body,omitempty"`
+	}{n.Title, n.Text}
+
+	ios := map[string]interface{}{"aps": struct {
+		Alert interface{} `json:"alert,omitempty"`
+		Sound string      `json:"sound,omitempty"`
+		*APNSOptions
+	}{alert, n.Sound, n.APNS}}
+	if err := checkPayload("iOS", ios, APNSPayloadLimit); err != nil {
+		return err
+	}
+
+	android := struct {
+		Notification interface{} `json:"notification,omitempty"`
+		Sound        string      `json:"sound,omitempty"`
+		*AndroidOptions
+	}{alert, n.Sound, n.Android}
+	return checkPayload("Android", android, AndroidPayloadLimit)
+}
+
+func checkPayload(platform string, payload interface{}, limit int) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if size := len(b) + pushPayloadOverhead; size > limit {
+		return fmt.Errorf("%w: %s payload is %d bytes, limit %d", ErrPayloadTooLarge, platform, size, limit)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package layer
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationPlatformOptions(t *testing.T) {
+	n := Notification{
+		Text:    "hi",
+		APNS:    &APNSOptions{Category: "reply", ThreadID: "conv1", MutableContent: true},
+		Android: &AndroidOptions{ChannelID: "messages", CollapseKey: "conv1", Data: map[string]string{"k": "v"}},
+	}
+	b, err := json.Marshal(n)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"text": "hi",
+		"apns": {"category": "reply", "thread-id": "conv1", "mutable-content": true},
+		"gcm": {"channel_id": "messages", "collapse_key": "conv1", "data": {"k": "v"}}
+	}`, string(b))
+
+	b, err = json.Marshal(Notification{Text: "hi"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"text": "hi"}`, string(b))
+}
+
+func TestNotificationValidate(t *testing.T) {
+	require.NoError(t, Notification{}.Validate())
+	require.NoError(t, Notification{Title: "t", Text: "hi", APNS: &APNSOptions{Category: "c"}}.Validate())
+
+	big := strings.Repeat("x", APNSPayloadLimit)
+	err := Notification{Text: big}.Validate()
+	require.True(t, errors.Is(err, ErrPayloadTooLarge))
+	require.Contains(t, err.Error(), "iOS")
+
+	err = Notification{Android: &AndroidOptions{Data: map[string]string{"k": big}}}.Validate()
+	require.True(t, errors.Is(err, ErrPayloadTooLarge))
+	require.Contains(t, err.Error(), "Android")
+
+	err = Notification{Recipients: map[string]Notification{"user1": Notification{Text: big}}}.Validate()
+	require.True(t, errors.Is(err, ErrPayloadTooLarge))
+	require.Contains(t, err.Error(), "user1")
+
+	_, err = l.SendMessage("conv", "user1", []Parts{TextPart("hi")}, Notification{Text: big})
+	require.True(t, errors.Is(err, ErrPayloadTooLarge))
+	_, err = l.SendAnnouncement(AnnouncementRequest{Recipients: []string{"user1"}, Notification: Notification{Text: big}})
+	require.True(t, errors.Is(err, ErrPayloadTooLarge))
+}
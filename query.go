@@ -0,0 +1,166 @@
+package layer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SortByCreated orders conversations by their creation time, newest first
+	SortByCreated = "created_at"
+	// SortByLastMessage orders conversations by the time of their last message, newest first
+	SortByLastMessage = "last_message"
+
+	maxPageSize = 100
+)
+
+// ConversationQuery describes a filter applied to every conversation a user belongs to.
+// Zero values are ignored, so an empty query matches every conversation.
+type ConversationQuery struct {
+	// Participants that must all be present in the conversation
+	Participants []string
+	// Metadata maps a dotted property path (e.g. "status" or "admin.name") to the value it must hold
+	Metadata map[string]interface{}
+	// Distinct restricts results to distinct or non-distinct conversations when set
+	Distinct *bool
+
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	ActiveAfter   time.Time
+	ActiveBefore  time.Time
+
+	// Filter is an optional predicate applied after all other conditions
+	Filter func(ConversationResponse) bool
+
+	// SortBy is either SortByCreated or SortByLastMessage, defaults to SortByCreated
+	SortBy string
+	// Limit caps the number of conversations returned, 0 means no limit
+	Limit int
+	// PageSize is the number of conversations requested per page, defaults to 100
+	PageSize int
+}
+
+// Match reports whether a conversation satisfies every condition of the query
+func (q ConversationQuery) Match(c ConversationResponse) bool {
+	for _, u := range q.Participants {
+		if !containsString(c.Participants, u) {
+			return false
+		}
+	}
+
+	for path, want := range q.Metadata {
+		got, ok := lookupMetadata(c.MetaData, path)
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+
+	if q.Distinct != nil && c.Distinct != *q.Distinct {
+		return false
+	}
+
+	if !inRange(c.Created, q.CreatedAfter, q.CreatedBefore) {
+		return false
+	}
+	if !inRange(c.LastMessage.SentAt, q.ActiveAfter, q.ActiveBefore) {
+		return false
+	}
+
+	if q.Filter != nil && !q.Filter(c) {
+		return false
+	}
+	return true
+}
+
+// QueryConversationsForUser walks every page of a user's conversations and returns those matching the query
+func (l *Layer) QueryConversationsForUser(userID string, q ConversationQuery) ([]ConversationResponse, error) {
+	if userID == "" {
+		return []ConversationResponse{}, ErrMissingUserID
+	}
+
+	sortBy := q.SortBy
+	if sortBy == "" {
+		sortBy = SortByCreated
+	}
+	if sortBy != SortByCreated && sortBy != SortByLastMessage {
+		return []ConversationResponse{}, fmt.Errorf("Invalid sort field %q", sortBy)
+	}
+
+	pageSize := q.PageSize
+	if pageSize <= 0 || pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	matches := []ConversationResponse{}
+	params := QueryParameters{PageSize: pageSize, SortBy: sortBy}
+	for {
+		page, err := l.GetAllConversationsForUser(userID, &params)
+		if err != nil {
+			return matches, err
+		}
+
+		for _, c := range page {
+			if q.Match(c) {
+				matches = append(matches, c)
+			}
+		}
+
+		if len(page) < pageSize || (q.Limit > 0 && len(matches) >= q.Limit) {
+			break
+		}
+		params.FromID = page[len(page)-1].GetID()
+	}
+
+	sortConversations(matches, sortBy)
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	return matches, nil
+}
+
+func sortConversations(c []ConversationResponse, sortBy string) {
+	key := func(i int) time.Time {
+		if sortBy == SortByLastMessage {
+			return c[i].LastMessage.SentAt
+		}
+		return c[i].Created
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		return key(i).After(key(j))
+	})
+}
+
+func lookupMetadata(md interface{}, path string) (interface{}, bool) {
+	cur := md
+	for _, k := range strings.Split(strings.TrimPrefix(path, "metadata."), ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func inRange(t, after, before time.Time) bool {
+	if !after.IsZero() && !t.After(after) {
+		return false
+	}
+	if !before.IsZero() && !t.Before(before) {
+		return false
+	}
+	return true
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package layer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationQueryMatch(t *testing.T) {
+	now := time.Now()
+	distinct := true
+	c := ConversationResponse{
+		Participants: []string{"user1", "user2"},
+		Distinct:     true,
+		Created:      now.Add(-48 * time.Hour),
+		MetaData:     map[string]interface{}{"status": "open", "admin": map[string]interface{}{"name": "fred"}},
+		LastMessage:  LastMessage{SentAt: now.Add(-time.Hour)},
+	}
+
+	require.True(t, ConversationQuery{}.Match(c))
+	require.True(t, ConversationQuery{Participants: []string{"user1"}}.Match(c))
+	require.False(t, ConversationQuery{Participants: []string{"user1", "user3"}}.Match(c))
+	require.True(t, ConversationQuery{Metadata: map[string]interface{}{"status": "open", "metadata.admin.name": "fred"}}.Match(c))
+	require.False(t, ConversationQuery{Metadata: map[string]interface{}{"status": "closed"}}.Match(c))
+	require.False(t, ConversationQuery{Metadata: map[string]interface{}{"admin.name.first": "fred"}}.Match(c))
+	require.True(t, ConversationQuery{Distinct: &distinct}.Match(c))
+	require.True(t, ConversationQuery{ActiveAfter: now.Add(-7 * 24 * time.Hour)}.Match(c))
+	require.False(t, ConversationQuery{CreatedAfter: now.Add(-24 * time.Hour)}.Match(c))
+	require.False(t, ConversationQuery{Filter: func(ConversationResponse) bool { return false }}.Match(c))
+}
+
+func TestSortConversations(t *testing.T) {
+	now := time.Now()
+	c := []ConversationResponse{
+		{ID: "a", Created: now.Add(-time.Hour), LastMessage: LastMessage{SentAt: now}},
+		{ID: "b", Created: now, LastMessage: LastMessage{SentAt: now.Add(-time.Hour)}},
+	}
+
+	sortConversations(c, SortByCreated)
+	require.Equal(t, "b", c[0].ID)
+
+	sortConversations(c, SortByLastMessage)
+	require.Equal(t, "a", c[0].ID)
+}
+
+func TestQueryConversationsForUser(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	user3 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	defer cleanUpConversation(res.GetID())
+
+	res2, err := l.CreateConversation([]string{user1, user3}, true, Conversation{})
+	require.NoError(t, err)
+	defer cleanUpConversation(res2.GetID())
+
+	resp, err := l.QueryConversationsForUser(user1, ConversationQuery{Participants: []string{user3}})
+	require.NoError(t, err)
+	require.Len(t, resp, 1)
+	require.Equal(t, res2.GetID(), resp[0].GetID())
+
+	resp, err = l.QueryConversationsForUser(user1, ConversationQuery{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, resp, 1)
+}
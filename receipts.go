@@ -0,0 +1,120 @@
+package layer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReceiptType is the kind of receipt a user can send for a message
+type ReceiptType string
+
+const (
+	// ReceiptDelivered marks a message as delivered to the user
+	ReceiptDelivered ReceiptType = "delivered"
+	// ReceiptRead marks a message as read by the user
+	ReceiptRead ReceiptType = "read"
+)
+
+var (
+	// ErrInvalidReceipt is returned when a receipt type other than ReceiptDelivered or ReceiptRead is given
+	ErrInvalidReceipt = errors.New("Invalid Receipt Type")
+	// ErrMessageNotInConversation is returned when a message ID does not belong to the given conversation
+	ErrMessageNotInConversation = errors.New("Message Not In Conversation")
+)
+
+type receiptBody struct {
+	Type ReceiptType `json:"type"`
+}
+
+// SendReceipt marks a single message as delivered or read on behalf of a user
+func (l *Layer) SendReceipt(userID, msgID string, receipt ReceiptType) (ok bool, err error) {
+	if userID == "" {
+		return false, ErrMissingUserID
+	}
+	if receipt != ReceiptDelivered && receipt != ReceiptRead {
+		return false, ErrInvalidReceipt
+	}
+
+	body, err := json.Marshal(&receiptBody{Type: receipt})
+	if err != nil {
+		return false, err
+	}
+
+	p := Parameters{Path: fmt.Sprintf("users/%s/messages/%s/receipts", userID, msgID), Body: body}
+	resp, err := l.request("POST", &p)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SendReceiptsUpTo marks every message in a conversation up to and including msgID as delivered or read on
+// behalf of a user. Messages the user sent, and messages whose status for the user is already at or past the
+// receipt, are skipped. It returns the number of messages marked.
+func (l *Layer) SendReceiptsUpTo(userID, convID, msgID string, receipt ReceiptType) (int, error) {
+	if userID == "" {
+		return 0, ErrMissingUserID
+	}
+	if receipt != ReceiptDelivered && receipt != ReceiptRead {
+		return 0, ErrInvalidReceipt
+	}
+
+	last, err := l.GetMessageForUser(userID, msgID)
+	if err != nil {
+		return 0, err
+	}
+	if last.GetID() != msgID {
+		return 0, fmt.Errorf("Message %s not found", msgID)
+	}
+	if strings.Replace(last.Conversation.ID, convHead, "", -1) != convID {
+		return 0, fmt.Errorf("%w: message %s, conversation %s", ErrMessageNotInConversation, msgID, convID)
+	}
+
+	marked := 0
+	if needsReceipt(last, userID, receipt) {
+		if _, err := l.SendReceipt(userID, msgID, receipt); err != nil {
+			return 0, err
+		}
+		marked++
+	}
+
+	// from_id pages through the messages older than msgID
+	path := fmt.Sprintf("users/%s/conversations/%s/messages", userID, convID)
+	params := QueryParameters{PageSize: maxPageSize, FromID: msgID}
+	for {
+		page, err := l.getMessages(path, &params)
+		if err != nil {
+			return marked, err
+		}
+
+		for _, m := range page {
+			if !needsReceipt(m, userID, receipt) {
+				continue
+			}
+			if _, err := l.SendReceipt(userID, m.GetID(), receipt); err != nil {
+				return marked, err
+			}
+			marked++
+		}
+
+		if len(page) < maxPageSize {
+			break
+		}
+		params.FromID = page[len(page)-1].GetID()
+	}
+	return marked, nil
+}
+
+func needsReceipt(m MessageResponse, userID string, receipt ReceiptType) bool {
+	if m.Sender.UserID == userID {
+		return false
+	}
+	return !m.RecipientStatus[userID].AtLeast(MessageStatus(receipt))
+}
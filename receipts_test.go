@@ -0,0 +1,81 @@
+package layer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeedsReceipt(t *testing.T) {
+	m := MessageResponse{
+		Sender:          Sender{UserID: "user1"},
+		RecipientStatus: RecipientStatus{"user1": "read", "user2": "delivered", "user3": "sent"},
+	}
+
+	require.False(t, needsReceipt(m, "user1", ReceiptRead))
+	require.False(t, needsReceipt(m, "user2", ReceiptDelivered))
+	require.True(t, needsReceipt(m, "user2", ReceiptRead))
+	require.True(t, needsReceipt(m, "user3", ReceiptDelivered))
+}
+
+func TestSendReceiptInvalid(t *testing.T) {
+	_, err := l.SendReceipt("user1", "msg", ReceiptType("seen"))
+	require.Equal(t, ErrInvalidReceipt, err)
+
+	_, err = l.SendReceipt("", "msg", ReceiptRead)
+	require.Equal(t, ErrMissingUserID, err)
+}
+
+func TestSendReceipt(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	res2, err := l.SendMessage(convID, user1, []Parts{TextPart("Hello World")}, Notification{})
+	require.NoError(t, err)
+	msgID := res2.GetID()
+
+	ok, err := l.SendReceipt(user2, msgID, ReceiptRead)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	res3, err := l.GetMessage(convID, msgID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRead, res3.RecipientStatus[user2])
+}
+
+func TestSendReceiptsUpTo(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	ids := []string{}
+	for _, body := range []string{"first", "second", "third"} {
+		m, err := l.SendMessage(convID, user1, []Parts{TextPart(body)}, Notification{})
+		require.NoError(t, err)
+		ids = append(ids, m.GetID())
+	}
+
+	n, err := l.SendReceiptsUpTo(user2, convID, ids[1], ReceiptRead)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	res2, err := l.GetMessage(convID, ids[2])
+	require.NoError(t, err)
+	require.NotEqual(t, StatusRead, res2.RecipientStatus[user2])
+
+	other, err := l.CreateConversation([]string{user1, user2, uuid.New()}, true, Conversation{})
+	require.NoError(t, err)
+	defer cleanUpConversation(other.GetID())
+
+	_, err = l.SendReceiptsUpTo(user2, other.GetID(), ids[2], ReceiptRead)
+	require.True(t, errors.Is(err, ErrMessageNotInConversation))
+}
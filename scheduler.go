@@ -0,0 +1,491 @@
+package layer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrJobNotFound is returned when cancelling or rescheduling an announcement that is not scheduled
+	ErrJobNotFound = errors.New("Scheduled Announcement Not Found")
+	// ErrSchedulerClosed is returned when scheduling with a scheduler that has been closed
+	ErrSchedulerClosed = errors.New("Scheduler Closed")
+	// ErrJobInFlight is returned when cancelling or rescheduling an announcement that is being sent
+	ErrJobInFlight = errors.New("Scheduled Announcement Is Being Sent")
+)
+
+// ScheduledAnnouncement is an announcement held by a Scheduler until SendAt. ID doubles as the dedupe ID sent
+// to Layer, so a job sent just before a crash is not sent again.
+type ScheduledAnnouncement struct {
+	ID           string              `json:"id"`
+	Announcement AnnouncementRequest `json:"announcement"`
+	SendAt       time.Time           `json:"send_at"`
+	Attempts     int                 `json:"attempts,omitempty"`
+}
+
+// JobStore persists the announcements of a Scheduler. Calls are serialized by the Scheduler.
+type JobStore interface {
+	// Save adds a job or replaces the job with the same ID
+	Save(j ScheduledAnnouncement) error
+	// Delete removes a job, returning ErrJobNotFound if there is none with that ID
+	Delete(id string) error
+	// List returns every stored job in any order
+	List() ([]ScheduledAnnouncement, error)
+}
+
+// Clock abstracts time so that schedulers can be driven by tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the Clock backed by the time package
+var SystemClock Clock = systemClock{}
+
+// SchedulerOptions configures a Scheduler
+type SchedulerOptions struct {
+	// Store holds the scheduled jobs, defaults to a new in-memory store
+	Store JobStore
+	// Clock defaults to SystemClock
+	Clock Clock
+	// MaxAttempts is the number of send attempts before a job fails, defaults to 5
+	MaxAttempts int
+	// RetryDelay is the delay before a job that failed temporarily is sent again, defaults to one minute
+	RetryDelay time.Duration
+	// OnSent is called from the scheduler goroutine once a job has been sent or has failed for good
+	OnSent func(j ScheduledAnnouncement, res AnnouncementResponse, err error)
+	// OnStoreError is called from the scheduler goroutine when a job that was sent or failed for good cannot be
+	// removed from the store. The job is not sent again, and removing it is retried every RetryDelay.
+	OnStoreError func(j ScheduledAnnouncement, err error)
+}
+
+// Scheduler sends announcements at a later time. Jobs are kept in its JobStore until sent, so with a persistent
+// store they survive a restart and are sent, late if need be, once a scheduler is created again.
+type Scheduler struct {
+	opts SchedulerOptions
+	send func(AnnouncementRequest, *string) (AnnouncementResponse, error)
+
+	mu     sync.Mutex
+	closed bool
+	// sending is the ID of the job being sent, if any
+	sending string
+	// finished holds the jobs that were sent or failed for good but are still in the store
+	finished map[string]bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewScheduler starts a scheduler that sends its announcements with this client
+func (l *Layer) NewScheduler(opts SchedulerOptions) *Scheduler {
+	return newScheduler(opts, l.sendAnnouncement)
+}
+
+func newScheduler(opts SchedulerOptions, send func(AnnouncementRequest, *string) (AnnouncementResponse, error)) *Scheduler {
+	if opts.Store == nil {
+		opts.Store = NewMemoryJobStore()
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Minute
+	}
+
+	s := &Scheduler{
+		opts:     opts,
+		send:     send,
+		finished: map[string]bool{},
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Schedule queues an announcement to be sent at the given time and returns the ID of the job
+func (s *Scheduler) Schedule(req AnnouncementRequest, at time.Time) (string, error) {
+	if err := validateRecipients(req.Recipients); err != nil {
+		return "", err
+	}
+	if err := req.Notification.Validate(); err != nil {
+		return "", err
+	}
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return "", ErrSchedulerClosed
+	}
+	if err := s.opts.Store.Save(ScheduledAnnouncement{ID: id, Announcement: req, SendAt: at}); err != nil {
+		return "", err
+	}
+	s.notify()
+	return id, nil
+}
+
+// ScheduleLocal queues an announcement to be sent at the wall clock time of at in the time zone of every
+// recipient. Recipients are grouped into one job per zone, recipients missing from zones use the location of at.
+// The IDs of the jobs are returned ordered by send time.
+func (s *Scheduler) ScheduleLocal(req AnnouncementRequest, at time.Time, zones map[string]*time.Location) ([]string, error) {
+	groups := map[*time.Location][]string{}
+	order := []*time.Location{}
+	for _, r := range req.Recipients {
+		loc := zones[r]
+		if loc == nil {
+			loc = at.Location()
+		}
+		if _, ok := groups[loc]; !ok {
+			order = append(order, loc)
+		}
+		groups[loc] = append(groups[loc], r)
+	}
+
+	sendAt := func(loc *time.Location) time.Time {
+		return time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), at.Minute(), at.Second(), at.Nanosecond(), loc)
+	}
+	sort.SliceStable(order, func(i, j int) bool { return sendAt(order[i]).Before(sendAt(order[j])) })
+
+	ids := []string{}
+	for _, loc := range order {
+		r := req
+		r.Recipients = groups[loc]
+		id, err := s.Schedule(r, sendAt(loc))
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Cancel removes a job before it is sent. A job that is being sent can no longer be cancelled, ErrJobInFlight is
+// returned for it.
+func (s *Scheduler) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.find(id); err != nil {
+		return err
+	}
+	if err := s.opts.Store.Delete(id); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// Reschedule moves a job to a new send time, unless it is being sent
+func (s *Scheduler) Reschedule(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.find(id)
+	if err != nil {
+		return err
+	}
+	j.SendAt = at
+	if err := s.opts.Store.Save(j); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// List returns the jobs waiting to be sent, ordered by send time
+func (s *Scheduler) List() ([]ScheduledAnnouncement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+// Close stops the scheduler. Jobs stay in the store.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+}
+
+// list returns the stored jobs still to be sent ordered by send time, s.mu must be held
+func (s *Scheduler) list() ([]ScheduledAnnouncement, error) {
+	stored, err := s.opts.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	jobs := []ScheduledAnnouncement{}
+	for _, j := range stored {
+		if !s.finished[j.ID] {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].SendAt.Before(jobs[j].SendAt) })
+	return jobs, nil
+}
+
+// find returns the stored job with the given ID if it is still to be sent and not being sent, s.mu must be held
+func (s *Scheduler) find(id string) (ScheduledAnnouncement, error) {
+	if id == s.sending {
+		return ScheduledAnnouncement{}, ErrJobInFlight
+	}
+	jobs, err := s.list()
+	if err != nil {
+		return ScheduledAnnouncement{}, err
+	}
+	for _, j := range jobs {
+		if j.ID == id {
+			return j, nil
+		}
+	}
+	return ScheduledAnnouncement{}, ErrJobNotFound
+}
+
+// purge retries removing finished jobs from the store, s.mu must be held
+func (s *Scheduler) purge() {
+	for id := range s.finished {
+		if err := s.opts.Store.Delete(id); err == nil || err == ErrJobNotFound {
+			delete(s.finished, id)
+		}
+	}
+}
+
+// notify wakes the scheduler goroutine to look at the store again
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.mu.Lock()
+		s.purge()
+		jobs, err := s.list()
+		pending := len(s.finished) > 0
+		s.mu.Unlock()
+
+		var timer <-chan time.Time
+		switch {
+		case err != nil:
+			timer = s.opts.Clock.After(s.opts.RetryDelay)
+		case len(jobs) > 0:
+			d := jobs[0].SendAt.Sub(s.opts.Clock.Now())
+			if d <= 0 {
+				s.fire(jobs[0].ID)
+				continue
+			}
+			timer = s.opts.Clock.After(d)
+		case pending:
+			timer = s.opts.Clock.After(s.opts.RetryDelay)
+		}
+
+		select {
+		case <-timer:
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// fire sends a due job. The job is marked as being sent so that it cannot be cancelled or rescheduled while the
+// lock is released during the request.
+func (s *Scheduler) fire(id string) {
+	s.mu.Lock()
+	j, err := s.find(id)
+	if err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.sending = j.ID
+	s.mu.Unlock()
+
+	j.Attempts++
+	dedupe := j.ID
+	res, err := s.send(j.Announcement, &dedupe)
+	if e, ok := err.(*APIError); ok && e.StatusCode == http.StatusConflict {
+		// Layer already holds the announcement sent for this job
+		res.ID, err = conflictID(e), nil
+	}
+
+	s.mu.Lock()
+	s.sending = ""
+	if err != nil && j.Attempts < s.opts.MaxAttempts && temporary(err) {
+		j.SendAt = s.opts.Clock.Now().Add(s.opts.RetryDelay)
+		if s.opts.Store.Save(j) == nil {
+			s.mu.Unlock()
+			return
+		}
+	}
+	storeErr := s.opts.Store.Delete(j.ID)
+	if storeErr != nil {
+		// never send the job again, removing it is retried by run
+		s.finished[j.ID] = true
+	}
+	s.mu.Unlock()
+
+	if storeErr != nil && s.opts.OnStoreError != nil {
+		s.opts.OnStoreError(j, storeErr)
+	}
+	if s.opts.OnSent != nil {
+		s.opts.OnSent(j, res, err)
+	}
+}
+
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]ScheduledAnnouncement
+}
+
+// NewMemoryJobStore returns a JobStore that keeps jobs in memory only
+func NewMemoryJobStore() JobStore {
+	return &memoryJobStore{jobs: map[string]ScheduledAnnouncement{}}
+}
+
+func (m *memoryJobStore) Save(j ScheduledAnnouncement) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.ID] = j
+	return nil
+}
+
+func (m *memoryJobStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[id]; !ok {
+		return ErrJobNotFound
+	}
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memoryJobStore) List() ([]ScheduledAnnouncement, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := []ScheduledAnnouncement{}
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+type fileJobStore struct {
+	memoryJobStore
+	path string
+}
+
+// NewFileJobStore returns a JobStore that keeps jobs in a JSON file at path, created if missing.
+// The file is atomically rewritten on every change.
+func NewFileJobStore(path string) (JobStore, error) {
+	f := &fileJobStore{memoryJobStore: memoryJobStore{jobs: map[string]ScheduledAnnouncement{}}, path: path}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []ScheduledAnnouncement{}
+	if err := json.Unmarshal(b, &jobs); err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		f.jobs[j.ID] = j
+	}
+	return f, nil
+}
+
+func (f *fileJobStore) Save(j ScheduledAnnouncement) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prev, existed := f.jobs[j.ID]
+	f.jobs[j.ID] = j
+	if err := f.write(); err != nil {
+		if existed {
+			f.jobs[j.ID] = prev
+		} else {
+			delete(f.jobs, j.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (f *fileJobStore) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	j, ok := f.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	delete(f.jobs, id)
+	if err := f.write(); err != nil {
+		f.jobs[id] = j
+		return err
+	}
+	return nil
+}
+
+// write replaces the file with the current jobs, f.mu must be held
+func (f *fileJobStore) write() error {
+	jobs := []ScheduledAnnouncement{}
+	for _, j := range f.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	b, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
@@ -0,0 +1,293 @@
+package layer
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := []fakeWaiter{}
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+type sentRecorder struct {
+	mu   sync.Mutex
+	sent []ScheduledAnnouncement
+	errs []error
+	ch   chan struct{}
+}
+
+func newSentRecorder() *sentRecorder {
+	return &sentRecorder{ch: make(chan struct{}, 100)}
+}
+
+func (r *sentRecorder) record(j ScheduledAnnouncement, res AnnouncementResponse, err error) {
+	r.mu.Lock()
+	r.sent = append(r.sent, j)
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+	r.ch <- struct{}{}
+}
+
+func (r *sentRecorder) wait(t *testing.T) {
+	select {
+	case <-r.ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a scheduled announcement")
+	}
+}
+
+func settle() {
+	time.Sleep(20 * time.Millisecond)
+}
+
+func okSend(req AnnouncementRequest, dedupe *string) (AnnouncementResponse, error) {
+	return AnnouncementResponse{ID: "a-" + *dedupe}, nil
+}
+
+func TestScheduler(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+	rec := newSentRecorder()
+	s := newScheduler(SchedulerOptions{Clock: clock, OnSent: rec.record}, okSend)
+	defer s.Close()
+
+	later, err := s.Schedule(AnnouncementRequest{Recipients: []string{"later"}}, clock.now.Add(2*time.Hour))
+	require.NoError(t, err)
+	sooner, err := s.Schedule(AnnouncementRequest{Recipients: []string{"sooner"}}, clock.now.Add(time.Hour))
+	require.NoError(t, err)
+	cancelled, err := s.Schedule(AnnouncementRequest{Recipients: []string{"cancelled"}}, clock.now.Add(30*time.Minute))
+	require.NoError(t, err)
+
+	jobs, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	require.Equal(t, cancelled, jobs[0].ID)
+
+	require.NoError(t, s.Cancel(cancelled))
+	require.Equal(t, ErrJobNotFound, s.Cancel(cancelled))
+	require.NoError(t, s.Reschedule(later, clock.now.Add(3*time.Hour)))
+	require.Equal(t, ErrJobNotFound, s.Reschedule("missing", clock.now))
+
+	settle()
+	clock.Advance(90 * time.Minute)
+	rec.wait(t)
+	require.Equal(t, sooner, rec.sent[0].ID)
+
+	settle()
+	clock.Advance(time.Hour)
+	settle()
+	jobs, err = s.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	clock.Advance(time.Hour)
+	rec.wait(t)
+	require.Equal(t, later, rec.sent[1].ID)
+	require.NoError(t, rec.errs[1])
+
+	s.Close()
+	_, err = s.Schedule(AnnouncementRequest{Recipients: []string{"u"}}, clock.now)
+	require.Equal(t, ErrSchedulerClosed, err)
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+	rec := newSentRecorder()
+	var attempts int64
+	send := func(req AnnouncementRequest, dedupe *string) (AnnouncementResponse, error) {
+		n := atomic.AddInt64(&attempts, 1)
+		if req.Recipients[0] == "bad" {
+			return AnnouncementResponse{}, &APIError{StatusCode: http.StatusUnprocessableEntity}
+		}
+		if n == 1 {
+			return AnnouncementResponse{}, &APIError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return AnnouncementResponse{}, &APIError{StatusCode: http.StatusConflict, Data: map[string]interface{}{"id": "existing"}}
+	}
+	s := newScheduler(SchedulerOptions{Clock: clock, RetryDelay: time.Minute, OnSent: rec.record}, send)
+	defer s.Close()
+
+	_, err := s.Schedule(AnnouncementRequest{Recipients: []string{"good"}}, clock.now)
+	require.NoError(t, err)
+	settle()
+	require.Equal(t, int64(1), atomic.LoadInt64(&attempts))
+	jobs, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, 1, jobs[0].Attempts)
+
+	clock.Advance(time.Minute)
+	rec.wait(t)
+	require.NoError(t, rec.errs[0])
+
+	_, err = s.Schedule(AnnouncementRequest{Recipients: []string{"bad"}}, clock.now)
+	require.NoError(t, err)
+	rec.wait(t)
+	require.Error(t, rec.errs[1])
+}
+
+func TestScheduleLocal(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	s := newScheduler(SchedulerOptions{Clock: &fakeClock{}}, okSend)
+	defer s.Close()
+
+	at := time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC)
+	zones := map[string]*time.Location{"a": ny, "b": tokyo, "c": ny}
+	ids, err := s.ScheduleLocal(AnnouncementRequest{Recipients: []string{"a", "b", "c", "d"}}, at, zones)
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+
+	jobs, err := s.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, jobs[0].Announcement.Recipients)
+	require.Equal(t, time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC), jobs[0].SendAt.UTC())
+	require.Equal(t, []string{"d"}, jobs[1].Announcement.Recipients)
+	require.Equal(t, []string{"a", "c"}, jobs[2].Announcement.Recipients)
+	require.Equal(t, time.Date(2017, 6, 1, 13, 0, 0, 0, time.UTC), jobs[2].SendAt.UTC())
+}
+
+func TestFileJobStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	store, err := NewFileJobStore(path)
+	require.NoError(t, err)
+
+	at := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Save(ScheduledAnnouncement{ID: "1", SendAt: at, Announcement: AnnouncementRequest{Recipients: []string{"u"}}}))
+	require.NoError(t, store.Save(ScheduledAnnouncement{ID: "2", SendAt: at}))
+	require.NoError(t, store.Delete("2"))
+	require.Equal(t, ErrJobNotFound, store.Delete("2"))
+
+	store, err = NewFileJobStore(path)
+	require.NoError(t, err)
+	jobs, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, "1", jobs[0].ID)
+	require.True(t, at.Equal(jobs[0].SendAt))
+	require.Equal(t, []string{"u"}, jobs[0].Announcement.Recipients)
+}
+
+// failingDeleteStore is a memory store whose Delete fails while failing is set, like a file store on a full disk
+type failingDeleteStore struct {
+	JobStore
+	failing int32
+}
+
+func (f *failingDeleteStore) Delete(id string) error {
+	if atomic.LoadInt32(&f.failing) == 1 {
+		return errors.New("disk full")
+	}
+	return f.JobStore.Delete(id)
+}
+
+func TestSchedulerDeleteFailure(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+	rec := newSentRecorder()
+	store := &failingDeleteStore{JobStore: NewMemoryJobStore(), failing: 1}
+	var sends, storeErrs int64
+	send := func(req AnnouncementRequest, dedupe *string) (AnnouncementResponse, error) {
+		atomic.AddInt64(&sends, 1)
+		return okSend(req, dedupe)
+	}
+	onStoreError := func(j ScheduledAnnouncement, err error) { atomic.AddInt64(&storeErrs, 1) }
+	s := newScheduler(SchedulerOptions{Store: store, Clock: clock, RetryDelay: time.Minute, OnSent: rec.record, OnStoreError: onStoreError}, send)
+	defer s.Close()
+
+	id, err := s.Schedule(AnnouncementRequest{Recipients: []string{"u"}}, clock.now)
+	require.NoError(t, err)
+	rec.wait(t)
+	settle()
+	require.Equal(t, int64(1), atomic.LoadInt64(&sends))
+	require.Equal(t, int64(1), atomic.LoadInt64(&storeErrs))
+
+	jobs, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 0)
+	require.Equal(t, ErrJobNotFound, s.Cancel(id))
+
+	atomic.StoreInt32(&store.failing, 0)
+	clock.Advance(time.Minute)
+	settle()
+	stored, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, stored, 0)
+	require.Equal(t, int64(1), atomic.LoadInt64(&sends))
+}
+
+func TestSchedulerInFlight(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+	rec := newSentRecorder()
+	started, release := make(chan struct{}), make(chan struct{})
+	send := func(req AnnouncementRequest, dedupe *string) (AnnouncementResponse, error) {
+		close(started)
+		<-release
+		return okSend(req, dedupe)
+	}
+	s := newScheduler(SchedulerOptions{Clock: clock, OnSent: rec.record}, send)
+	defer s.Close()
+
+	id, err := s.Schedule(AnnouncementRequest{Recipients: []string{"u"}}, clock.now)
+	require.NoError(t, err)
+	<-started
+
+	// the scheduler stays usable while the request is in flight
+	other, err := s.Schedule(AnnouncementRequest{Recipients: []string{"v"}}, clock.now.Add(time.Hour))
+	require.NoError(t, err)
+	jobs, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, ErrJobInFlight, s.Cancel(id))
+	require.Equal(t, ErrJobInFlight, s.Reschedule(id, clock.now.Add(time.Hour)))
+	require.NoError(t, s.Cancel(other))
+
+	close(release)
+	rec.wait(t)
+	require.Equal(t, id, rec.sent[0].ID)
+	require.NoError(t, rec.errs[0])
+}
@@ -0,0 +1,76 @@
+package layer
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Schema is the shape of the responses returned by an API version, selected by the version passed to NewLayer
+type Schema struct {
+	// Version is the version sent in the Accept header
+	Version string
+	// Identities is true for API version 2.0 and later, which return participants and senders as identities
+	Identities bool
+}
+
+// SchemaFor returns the schema of an API version such as "1.0" or "2.0"
+func SchemaFor(version string) Schema {
+	major := version
+	if i := strings.Index(version, "."); i >= 0 {
+		major = version[:i]
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(major))
+	return Schema{Version: version, Identities: err == nil && n >= 2}
+}
+
+// Schema returns the schema of the API version the client was created with
+func (l *Layer) Schema() Schema {
+	return SchemaFor(l.version)
+}
+
+// decode reads a response body into v. Conversations and messages are decoded whichever shape they arrive in,
+// then completed according to the schema of the client's API version.
+func (l *Layer) decode(r io.Reader, v interface{}) error {
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return err
+	}
+
+	s := l.Schema()
+	switch t := v.(type) {
+	case *ConversationResponse:
+		s.conversation(t)
+	case *[]ConversationResponse:
+		for i := range *t {
+			s.conversation(&(*t)[i])
+		}
+	case *MessageResponse:
+		s.sender(&t.Sender)
+	case *[]MessageResponse:
+		for i := range *t {
+			s.sender(&(*t)[i].Sender)
+		}
+	}
+	return nil
+}
+
+// conversation fills in the participant identities of version 2.0 conversations returned with user IDs only
+func (s Schema) conversation(c *ConversationResponse) {
+	s.sender(&c.LastMessage.Sender)
+	if !s.Identities || len(c.Identities) == len(c.Participants) {
+		return
+	}
+
+	c.Identities = []Identity{}
+	for _, u := range c.Participants {
+		c.Identities = append(c.Identities, Identity{ID: identityHead + u, UserID: u})
+	}
+}
+
+// sender fills in the identity ID of version 2.0 senders returned with a user ID only
+func (s Schema) sender(x *Sender) {
+	if s.Identities && x.ID == "" && x.UserID != "" {
+		x.ID = identityHead + x.UserID
+	}
+}
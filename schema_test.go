@@ -0,0 +1,45 @@
+package layer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaFor(t *testing.T) {
+	require.False(t, SchemaFor("1.0").Identities)
+	require.False(t, SchemaFor("1.1").Identities)
+	require.True(t, SchemaFor("2.0").Identities)
+	require.True(t, SchemaFor("3").Identities)
+	require.False(t, SchemaFor("").Identities)
+	require.Equal(t, "2.0", NewLayer("", "", "2.0", time.Second).Schema().Version)
+}
+
+func TestDecodeBySchema(t *testing.T) {
+	v1 := NewLayer("", "", "1.0", time.Second)
+	v2 := NewLayer("", "", "2.0", time.Second)
+
+	cr := ConversationResponse{}
+	require.NoError(t, v1.decode(bytes.NewReader(loadFixture(t, "conversation_v1.json")), &cr))
+	require.Len(t, cr.Identities, 0)
+	require.Empty(t, cr.LastMessage.Sender.ID)
+
+	// a version 2.0 client gets identities even from a payload carrying user IDs only
+	cr = ConversationResponse{}
+	require.NoError(t, v2.decode(bytes.NewReader(loadFixture(t, "conversation_v1.json")), &cr))
+	require.Equal(t, []Identity{Identity{ID: identityHead + "1234", UserID: "1234"}, Identity{ID: identityHead + "5678", UserID: "5678"}}, cr.Identities)
+	require.Equal(t, identityHead+"1234", cr.LastMessage.Sender.ID)
+
+	crs := []ConversationResponse{}
+	payload := append(append([]byte("["), loadFixture(t, "conversation_v2.json")...), ']')
+	require.NoError(t, v2.decode(bytes.NewReader(payload), &crs))
+	require.Equal(t, "One Two Three Four", crs[0].Identities[0].DisplayName)
+
+	ms := []MessageResponse{}
+	payload = append(append([]byte("["), loadFixture(t, "message_v1.json")...), ']')
+	require.NoError(t, v2.decode(bytes.NewReader(payload), &ms))
+	require.Equal(t, "t-bone", ms[0].Sender.Name)
+	require.Empty(t, ms[0].Sender.ID)
+}
@@ -0,0 +1,91 @@
+package layer
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// MessageStatus is the state of a message for one of its recipients
+type MessageStatus string
+
+const (
+	// StatusSent is a message that has not yet reached the recipient's devices
+	StatusSent MessageStatus = "sent"
+	// StatusDelivered is a message that reached the recipient's devices but has not been read
+	StatusDelivered MessageStatus = "delivered"
+	// StatusRead is a message the recipient has read
+	StatusRead MessageStatus = "read"
+)
+
+var statusRank = map[MessageStatus]int{StatusSent: 1, StatusDelivered: 2, StatusRead: 3}
+
+// AtLeast reports whether the status is the same as or further along than o. Unknown statuses are behind all others.
+func (s MessageStatus) AtLeast(o MessageStatus) bool {
+	return statusRank[s] >= statusRank[o]
+}
+
+// RecipientStatus maps the user ID of each recipient to the status of a message for them
+type RecipientStatus map[string]MessageStatus
+
+// UnmarshalJSON keys the status by user ID, as later API versions key it by identity ID
+func (r *RecipientStatus) UnmarshalJSON(b []byte) error {
+	m := map[string]MessageStatus{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	*r = RecipientStatus{}
+	for k, v := range m {
+		(*r)[strings.Replace(k, identityHead, "", -1)] = v
+	}
+	return nil
+}
+
+// ReadBy returns the sorted IDs of the users who have read the message
+func (r RecipientStatus) ReadBy() []string {
+	return r.filter(func(s MessageStatus) bool { return s == StatusRead })
+}
+
+// DeliveredTo returns the sorted IDs of the users the message has reached, whether or not they have read it
+func (r RecipientStatus) DeliveredTo() []string {
+	return r.filter(func(s MessageStatus) bool { return s.AtLeast(StatusDelivered) })
+}
+
+// PendingFor returns the sorted IDs of the users for whom the message has not yet reached status
+func (r RecipientStatus) PendingFor(status MessageStatus) []string {
+	return r.filter(func(s MessageStatus) bool { return !s.AtLeast(status) })
+}
+
+// AllRead reports whether every recipient has read the message
+func (r RecipientStatus) AllRead() bool {
+	return len(r.PendingFor(StatusRead)) == 0
+}
+
+func (r RecipientStatus) filter(fn func(MessageStatus) bool) []string {
+	users := []string{}
+	for u, s := range r {
+		if fn(s) {
+			users = append(users, u)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// UnreadCounts computes the number of messages each recipient has not read from a conversation's message
+// history. Messages are never counted as unread for their own sender.
+func UnreadCounts(messages []MessageResponse) map[string]int {
+	counts := map[string]int{}
+	for _, m := range messages {
+		for u, s := range m.RecipientStatus {
+			if _, ok := counts[u]; !ok {
+				counts[u] = 0
+			}
+			if u != m.Sender.UserID && !s.AtLeast(StatusRead) {
+				counts[u]++
+			}
+		}
+	}
+	return counts
+}
@@ -0,0 +1,37 @@
+package layer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageStatusAtLeast(t *testing.T) {
+	require.True(t, StatusRead.AtLeast(StatusDelivered))
+	require.True(t, StatusDelivered.AtLeast(StatusDelivered))
+	require.False(t, StatusSent.AtLeast(StatusDelivered))
+	require.False(t, MessageStatus("").AtLeast(StatusSent))
+}
+
+func TestRecipientStatusHelpers(t *testing.T) {
+	r := RecipientStatus{"user1": StatusRead, "user2": StatusDelivered, "user3": StatusSent, "user4": StatusRead}
+
+	require.Equal(t, []string{"user1", "user4"}, r.ReadBy())
+	require.Equal(t, []string{"user1", "user2", "user4"}, r.DeliveredTo())
+	require.Equal(t, []string{"user2", "user3"}, r.PendingFor(StatusRead))
+	require.Equal(t, []string{"user3"}, r.PendingFor(StatusDelivered))
+	require.False(t, r.AllRead())
+	require.True(t, RecipientStatus{"user1": StatusRead}.AllRead())
+	require.Equal(t, []string{}, RecipientStatus{}.ReadBy())
+}
+
+func TestUnreadCounts(t *testing.T) {
+	messages := []MessageResponse{
+		{Sender: Sender{UserID: "user1"}, RecipientStatus: RecipientStatus{"user1": StatusRead, "user2": StatusRead, "user3": StatusDelivered}},
+		{Sender: Sender{UserID: "user2"}, RecipientStatus: RecipientStatus{"user1": StatusSent, "user2": StatusSent, "user3": StatusDelivered}},
+		{Sender: Sender{Name: "Order Bot"}, RecipientStatus: RecipientStatus{"user1": StatusRead, "user2": StatusDelivered, "user3": StatusSent}},
+	}
+
+	require.Equal(t, map[string]int{"user1": 1, "user2": 1, "user3": 3}, UnreadCounts(messages))
+	require.Equal(t, map[string]int{}, UnreadCounts(nil))
+}
@@ -0,0 +1,196 @@
+package layer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var (
+	// ErrTemplateNotFound is returned when rendering a template name that was never registered
+	ErrTemplateNotFound = errors.New("Template Not Found")
+	// ErrInvalidJSON is returned when a JSON part template renders to invalid JSON
+	ErrInvalidJSON = errors.New("Invalid JSON Body")
+)
+
+// MessageTemplate describes a message whose part bodies and notification title and text are text/template
+// sources. Templates are executed with the data passed when rendering, and fail on missing map keys.
+// Templates of JSON parts should insert values with the json function, e.g. {"order":{{json .Order}}}, which
+// quotes and escapes them.
+type MessageTemplate struct {
+	Parts             []PartTemplate
+	NotificationTitle string
+	NotificationText  string
+	Sound             string
+}
+
+// PartTemplate is the template for a single message part
+type PartTemplate struct {
+	MimeType string
+	Encoding string
+	Body     string
+}
+
+// RenderedMessage is the payload produced by a template, ready to be sent or previewed
+type RenderedMessage struct {
+	Parts        []Parts
+	Notification Notification
+}
+
+// TemplateSet holds named message templates, parsed and validated when registered
+type TemplateSet struct {
+	mu        sync.RWMutex
+	templates map[string]*compiledTemplate
+}
+
+type compiledTemplate struct {
+	src   MessageTemplate
+	parts []*template.Template
+	title *template.Template
+	text  *template.Template
+}
+
+// NewTemplateSet returns an empty TemplateSet
+func NewTemplateSet() *TemplateSet {
+	return &TemplateSet{templates: map[string]*compiledTemplate{}}
+}
+
+// Register parses a template under name, replacing any template of the same name. It fails if the template has
+// no parts, a part has an invalid MIME type or encoding, or any of its sources does not parse.
+func (s *TemplateSet) Register(name string, t MessageTemplate) error {
+	if len(t.Parts) == 0 {
+		return fmt.Errorf("Template %s: %w", name, ErrEmptyParts)
+	}
+
+	c := compiledTemplate{src: t}
+	for i, p := range t.Parts {
+		if err := ValidatePart(Parts{MimeType: p.MimeType, Encoding: p.Encoding}); err != nil {
+			return fmt.Errorf("Template %s part %d: %w", name, i, err)
+		}
+
+		pt, err := parseTemplate(fmt.Sprintf("%s.part%d", name, i), p.Body)
+		if err != nil {
+			return err
+		}
+		c.parts = append(c.parts, pt)
+	}
+
+	var err error
+	if c.title, err = parseTemplate(name+".title", t.NotificationTitle); err != nil {
+		return err
+	}
+	if c.text, err = parseTemplate(name+".text", t.NotificationText); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.templates[name] = &c
+	s.mu.Unlock()
+	return nil
+}
+
+// Render executes the named template with data. Notifications for the users in recipientData are rendered with
+// their own data instead, e.g. to address each recipient by name.
+func (s *TemplateSet) Render(name string, data interface{}, recipientData map[string]interface{}) (RenderedMessage, error) {
+	s.mu.RLock()
+	c, ok := s.templates[name]
+	s.mu.RUnlock()
+	if !ok {
+		return RenderedMessage{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	r := RenderedMessage{}
+	for i, pt := range c.parts {
+		body, err := execute(pt, data)
+		if err != nil {
+			return RenderedMessage{}, err
+		}
+		p := Parts{MimeType: c.src.Parts[i].MimeType, Encoding: c.src.Parts[i].Encoding, Body: body}
+		if isJSONMimeType(p.MimeType) && p.Encoding == "" && !json.Valid([]byte(body)) {
+			return RenderedMessage{}, fmt.Errorf("Template %s part %d: %w", name, i, ErrInvalidJSON)
+		}
+		r.Parts = append(r.Parts, p)
+	}
+	if err := ValidateParts(r.Parts); err != nil {
+		return RenderedMessage{}, fmt.Errorf("Template %s: %w", name, err)
+	}
+
+	def, err := c.notification(data)
+	if err != nil {
+		return RenderedMessage{}, err
+	}
+
+	b := NewNotificationBuilder(def)
+	for u, d := range recipientData {
+		n, err := c.notification(d)
+		if err != nil {
+			return RenderedMessage{}, fmt.Errorf("Recipient %s: %w", u, err)
+		}
+		b.Override(n, u)
+	}
+
+	if r.Notification, err = b.Build(nil); err != nil {
+		return RenderedMessage{}, err
+	}
+	return r, nil
+}
+
+func (c *compiledTemplate) notification(data interface{}) (Notification, error) {
+	title, err := execute(c.title, data)
+	if err != nil {
+		return Notification{}, err
+	}
+	text, err := execute(c.text, data)
+	if err != nil {
+		return Notification{}, err
+	}
+	return Notification{Title: title, Text: text, Sound: c.src.Sound}, nil
+}
+
+// SendTemplatedMessage renders the named template and sends it to a conversation with SendMessage
+func (l *Layer) SendTemplatedMessage(s *TemplateSet, name, convID, sender string, data interface{}, recipientData map[string]interface{}) (MessageResponse, error) {
+	r, err := s.Render(name, data, recipientData)
+	if err != nil {
+		return MessageResponse{}, err
+	}
+	return l.SendMessage(convID, sender, r.Parts, r.Notification)
+}
+
+// SendTemplatedAnnouncement renders the named template and sends it to recipients with SendAnnouncement
+func (l *Layer) SendTemplatedAnnouncement(s *TemplateSet, name string, recipients []string, sender Sender, data interface{}, recipientData map[string]interface{}) (AnnouncementResponse, error) {
+	r, err := s.Render(name, data, recipientData)
+	if err != nil {
+		return AnnouncementResponse{}, err
+	}
+	return l.SendAnnouncement(AnnouncementRequest{Recipients: recipients, Sender: sender, Parts: r.Parts, Notification: r.Notification})
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseTemplate(name, src string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(src)
+}
+
+// isJSONMimeType reports whether a MIME type is application/json or a +json type
+func isJSONMimeType(mimeType string) bool {
+	t, _, err := mime.ParseMediaType(mimeType)
+	return err == nil && (t == "application/json" || strings.HasSuffix(t, "+json"))
+}
+
+func execute(t *template.Template, data interface{}) (string, error) {
+	buf := bytes.Buffer{}
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,106 @@
+package layer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var shipped = MessageTemplate{
+	Parts: []PartTemplate{
+		PartTemplate{MimeType: "text/plain", Body: "Order {{.Order}} has shipped"},
+		PartTemplate{MimeType: "application/json", Body: `{"order":"{{.Order}}"}`},
+	},
+	NotificationTitle: "Order shipped",
+	NotificationText:  "Hi {{.Name}}, order {{.Order}} is on its way",
+	Sound:             "chime.aiff",
+}
+
+func TestTemplateSetRegister(t *testing.T) {
+	s := NewTemplateSet()
+	require.NoError(t, s.Register("shipped", shipped))
+
+	err := s.Register("empty", MessageTemplate{})
+	require.True(t, errors.Is(err, ErrEmptyParts))
+
+	err = s.Register("mime", MessageTemplate{Parts: []PartTemplate{PartTemplate{MimeType: "plain", Body: "hi"}}})
+	require.True(t, errors.Is(err, ErrInvalidMimeType))
+
+	err = s.Register("syntax", MessageTemplate{Parts: []PartTemplate{PartTemplate{MimeType: "text/plain", Body: "{{.Order"}}})
+	require.Error(t, err)
+
+	err = s.Register("title", MessageTemplate{Parts: []PartTemplate{PartTemplate{MimeType: "text/plain"}}, NotificationTitle: "{{end}}"})
+	require.Error(t, err)
+}
+
+func TestTemplateSetRender(t *testing.T) {
+	s := NewTemplateSet()
+	require.NoError(t, s.Register("shipped", shipped))
+
+	data := map[string]interface{}{"Order": "A-1", "Name": "there"}
+	recipients := map[string]interface{}{
+		"user2": map[string]interface{}{"Order": "A-1", "Name": "Fred"},
+	}
+	r, err := s.Render("shipped", data, recipients)
+	require.NoError(t, err)
+	require.Len(t, r.Parts, 2)
+	require.Equal(t, Parts{MimeType: "text/plain", Body: "Order A-1 has shipped"}, r.Parts[0])
+	require.Equal(t, `{"order":"A-1"}`, r.Parts[1].Body)
+	require.Equal(t, "Order shipped", r.Notification.Title)
+	require.Equal(t, "Hi there, order A-1 is on its way", r.Notification.Text)
+	require.Equal(t, "chime.aiff", r.Notification.Sound)
+	require.Equal(t, "Hi Fred, order A-1 is on its way", r.Notification.Recipients["user2"].Text)
+
+	_, err = s.Render("shipped", map[string]interface{}{"Name": "there"}, nil)
+	require.Error(t, err)
+
+	_, err = s.Render("shipped", data, map[string]interface{}{"user3": map[string]interface{}{}})
+	require.Error(t, err)
+
+	_, err = s.Render("missing", data, nil)
+	require.True(t, errors.Is(err, ErrTemplateNotFound))
+}
+
+func TestTemplateSetRenderJSON(t *testing.T) {
+	s := NewTemplateSet()
+	require.NoError(t, s.Register("shipped", shipped))
+	require.NoError(t, s.Register("escaped", MessageTemplate{Parts: []PartTemplate{
+		PartTemplate{MimeType: "application/json", Body: `{"order":{{json .Order}}}`},
+	}}))
+
+	data := map[string]interface{}{"Order": `A"1\`, "Name": "there"}
+	_, err := s.Render("shipped", data, nil)
+	require.True(t, errors.Is(err, ErrInvalidJSON))
+
+	r, err := s.Render("escaped", data, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"order":"A\"1\\"}`, r.Parts[0].Body)
+
+	require.NoError(t, s.Register("big", MessageTemplate{Parts: []PartTemplate{PartTemplate{MimeType: "text/plain", Body: "{{.Body}}"}}}))
+	_, err = s.Render("big", map[string]interface{}{"Body": strings.Repeat("x", MaxInlineBodySize+1)}, nil)
+	require.True(t, errors.Is(err, ErrPartTooLarge))
+
+	require.NoError(t, s.Register("b64", MessageTemplate{Parts: []PartTemplate{PartTemplate{MimeType: "image/png", Encoding: Base64Encoding, Body: "{{.Body}}"}}}))
+	_, err = s.Render("b64", map[string]interface{}{"Body": "not base64!"}, nil)
+	require.True(t, errors.Is(err, ErrInvalidEncoding))
+}
+
+func TestSendTemplatedMessage(t *testing.T) {
+	user1 := uuid.New()
+	user2 := uuid.New()
+	res, err := l.CreateConversation([]string{user1, user2}, true, Conversation{})
+	require.NoError(t, err)
+	convID := res.GetID()
+	defer cleanUpConversation(convID)
+
+	s := NewTemplateSet()
+	require.NoError(t, s.Register("shipped", shipped))
+
+	data := map[string]interface{}{"Order": "A-1", "Name": "there"}
+	res2, err := l.SendTemplatedMessage(s, "shipped", convID, user1, data, nil)
+	require.NoError(t, err)
+	require.Equal(t, "Order A-1 has shipped", res2.Parts[0].Body)
+}